@@ -0,0 +1,449 @@
+package gondor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertKind is how an alert rule evaluates its metric.
+type AlertKind string
+
+const (
+	// AlertThreshold compares the latest value against the threshold.
+	AlertThreshold AlertKind = "threshold"
+	// AlertRateOfChange compares the per-second change across the window
+	// against the threshold.
+	AlertRateOfChange AlertKind = "rate"
+)
+
+// AlertRule describes a condition on a service metric.
+type AlertRule struct {
+	// Name identifies the rule and keys its persisted state.
+	Name       string
+	ServiceURL string
+	Metric     string
+	Kind       AlertKind
+	// Above fires when the evaluated value exceeds Threshold; otherwise
+	// the rule fires when it drops below.
+	Above     bool
+	Threshold float64
+	// Window is the range of metric data evaluated; defaults to five
+	// minutes.
+	Window time.Duration
+	// For is how long the condition must hold before the alert fires.
+	For time.Duration
+	// Resolve is the value the metric must cross back over for a firing
+	// alert to resolve. It defaults to Threshold; setting it further from
+	// the firing side adds hysteresis.
+	Resolve *float64
+}
+
+func (rule AlertRule) validate() error {
+	switch {
+	case rule.Name == "":
+		return errors.New("alert rule: name is required")
+	case rule.ServiceURL == "" || rule.Metric == "":
+		return fmt.Errorf("alert rule %q: service URL and metric are required", rule.Name)
+	}
+	switch rule.Kind {
+	case AlertThreshold, AlertRateOfChange:
+	default:
+		return fmt.Errorf("alert rule %q: unknown kind %q", rule.Name, rule.Kind)
+	}
+	if rule.Resolve != nil && (rule.Above && *rule.Resolve > rule.Threshold || !rule.Above && *rule.Resolve < rule.Threshold) {
+		return fmt.Errorf("alert rule %q: resolve value is on the firing side of the threshold", rule.Name)
+	}
+	return nil
+}
+
+func (rule AlertRule) window() time.Duration {
+	if rule.Window <= 0 {
+		return 5 * time.Minute
+	}
+	return rule.Window
+}
+
+func (rule AlertRule) breached(v float64) bool {
+	if rule.Above {
+		return v > rule.Threshold
+	}
+	return v < rule.Threshold
+}
+
+func (rule AlertRule) recovered(v float64) bool {
+	resolve := rule.Threshold
+	if rule.Resolve != nil {
+		resolve = *rule.Resolve
+	}
+	if rule.Above {
+		return v <= resolve
+	}
+	return v >= resolve
+}
+
+// evaluate reduces points to the value compared against the threshold.
+func (rule AlertRule) evaluate(points []MetricPoint) (float64, error) {
+	if len(points) == 0 {
+		return 0, fmt.Errorf("alert rule %q: metric %q has no points", rule.Name, rule.Metric)
+	}
+	last := points[len(points)-1]
+	if rule.Kind == AlertThreshold {
+		return last.Value, nil
+	}
+	first := points[0]
+	elapsed := last.Time.Sub(first.Time).Seconds()
+	if elapsed <= 0 {
+		return 0, fmt.Errorf("alert rule %q: not enough points to compute a rate", rule.Name)
+	}
+	return (last.Value - first.Value) / elapsed, nil
+}
+
+// AlertStatus is the state of an alert rule.
+type AlertStatus string
+
+const (
+	AlertInactive AlertStatus = "inactive"
+	AlertPending  AlertStatus = "pending"
+	AlertFiring   AlertStatus = "firing"
+)
+
+// AlertState is the persisted state of one rule.
+type AlertState struct {
+	Status AlertStatus `json:"status"`
+	// Since is when the rule entered Status.
+	Since time.Time `json:"since"`
+	Value float64   `json:"value"`
+	// NotifyPending is set on a transition to or from firing until every
+	// notifier has accepted the notification; evaluations retry it.
+	NotifyPending bool `json:"notify_pending,omitempty"`
+}
+
+// AlertNotification is sent to notifiers when an alert fires or resolves.
+type AlertNotification struct {
+	Rule     string    `json:"rule"`
+	Service  string    `json:"service"`
+	Metric   string    `json:"metric"`
+	Firing   bool      `json:"firing"`
+	Value    float64   `json:"value"`
+	Since    time.Time `json:"since"`
+	Notified time.Time `json:"notified"`
+}
+
+func (n AlertNotification) String() string {
+	status := "RESOLVED"
+	if n.Firing {
+		status = "FIRING"
+	}
+	return fmt.Sprintf("[%s] %s: %s=%g (since %s)", status, n.Rule, n.Metric, n.Value, n.Since.Format(time.RFC3339))
+}
+
+// AlertNotifier delivers alert notifications.
+type AlertNotifier interface {
+	Notify(AlertNotification) error
+}
+
+// AlertEvaluator periodically evaluates alert rules against service
+// metrics and notifies on every transition to or from firing. A failed
+// notification is retried on every evaluation until it is delivered.
+// With a state file, pending and firing alerts and undelivered
+// notifications survive restarts without repeating notifications.
+type AlertEvaluator struct {
+	// Interval between evaluations; defaults to one minute.
+	Interval time.Duration
+	// StatePath, if set, is the file alert state is persisted to.
+	StatePath string
+	// OnError, if set, is called with evaluation and notification errors.
+	OnError func(rule string, err error)
+
+	client    *Client
+	rules     []AlertRule
+	notifiers []AlertNotifier
+
+	mu    sync.Mutex
+	state map[string]*AlertState
+}
+
+// NewAlertEvaluator returns an evaluator for rules.
+func NewAlertEvaluator(client *Client, rules []AlertRule, notifiers ...AlertNotifier) (*AlertEvaluator, error) {
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %q is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return &AlertEvaluator{
+		Interval:  time.Minute,
+		client:    client,
+		rules:     rules,
+		notifiers: notifiers,
+		state:     make(map[string]*AlertState),
+	}, nil
+}
+
+// Run loads persisted state and evaluates every Interval until ctx is
+// done.
+func (e *AlertEvaluator) Run(ctx context.Context) error {
+	if err := e.load(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(durationOrDefault(e.Interval, time.Minute))
+	defer ticker.Stop()
+	for {
+		if err := e.Evaluate(time.Now()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// State returns a copy of the current state of every rule.
+func (e *AlertEvaluator) State() map[string]AlertState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make(map[string]AlertState, len(e.state))
+	for k, v := range e.state {
+		res[k] = *v
+	}
+	return res
+}
+
+// Evaluate runs every rule once as of now and persists the result. Only a
+// failure to persist state is returned; rule errors go to OnError.
+func (e *AlertEvaluator) Evaluate(now time.Time) error {
+	for _, rule := range e.rules {
+		if err := e.evaluate(rule, now); err != nil && e.OnError != nil {
+			e.OnError(rule.Name, err)
+		}
+		if err := e.notify(rule, now); err != nil && e.OnError != nil {
+			e.OnError(rule.Name, err)
+		}
+	}
+	return e.save()
+}
+
+func (e *AlertEvaluator) evaluate(rule AlertRule, now time.Time) error {
+	results, err := e.client.Metrics.Query(MetricQuery{
+		Service: rule.ServiceURL,
+		Start:   now.Add(-rule.window()),
+		End:     now,
+		Metrics: []string{rule.Metric},
+	})
+	if err != nil {
+		return err
+	}
+	var points []MetricPoint
+	for _, res := range results {
+		if res.Name == rule.Metric {
+			points = res.Points
+		}
+	}
+	value, err := rule.evaluate(points)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	st, ok := e.state[rule.Name]
+	if !ok {
+		st = &AlertState{Status: AlertInactive, Since: now}
+		e.state[rule.Name] = st
+	}
+	st.Value = value
+	switch st.Status {
+	case AlertInactive:
+		if rule.breached(value) {
+			st.Status, st.Since = AlertPending, now
+		}
+	case AlertPending:
+		if !rule.breached(value) {
+			st.Status, st.Since = AlertInactive, now
+		}
+	case AlertFiring:
+		if rule.recovered(value) {
+			st.Status, st.Since = AlertInactive, now
+			st.NotifyPending = true
+		}
+	}
+	if st.Status == AlertPending && now.Sub(st.Since) >= rule.For {
+		st.Status, st.Since = AlertFiring, now
+		st.NotifyPending = true
+	}
+	e.mu.Unlock()
+	return nil
+}
+
+// notify delivers the pending notification of rule, if any. It stays
+// pending unless every notifier accepts it, so notifiers that did accept
+// it may see it again on the retry.
+func (e *AlertEvaluator) notify(rule AlertRule, now time.Time) error {
+	e.mu.Lock()
+	st, ok := e.state[rule.Name]
+	if !ok || !st.NotifyPending {
+		e.mu.Unlock()
+		return nil
+	}
+	notify := AlertNotification{
+		Rule:     rule.Name,
+		Service:  rule.ServiceURL,
+		Metric:   rule.Metric,
+		Firing:   st.Status == AlertFiring,
+		Value:    st.Value,
+		Since:    st.Since,
+		Notified: now,
+	}
+	e.mu.Unlock()
+	var errs []string
+	for _, n := range e.notifiers {
+		if err := n.Notify(notify); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notifying %q: %s", rule.Name, strings.Join(errs, "; "))
+	}
+	e.mu.Lock()
+	// a newer transition since the notification was built stays pending
+	if st.Since.Equal(notify.Since) && (st.Status == AlertFiring) == notify.Firing {
+		st.NotifyPending = false
+	}
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *AlertEvaluator) load() error {
+	if e.StatePath == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(e.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := make(map[string]*AlertState)
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("alert state %s: %s", e.StatePath, err)
+	}
+	e.mu.Lock()
+	e.state = state
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *AlertEvaluator) save() error {
+	if e.StatePath == "" {
+		return nil
+	}
+	e.mu.Lock()
+	b, err := json.MarshalIndent(e.state, "", "  ")
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(e.StatePath, b)
+}
+
+// WriterNotifier writes one line per notification to W.
+type WriterNotifier struct {
+	W io.Writer
+}
+
+// NewStdoutNotifier returns a notifier printing to standard output.
+func NewStdoutNotifier() *WriterNotifier {
+	return &WriterNotifier{W: os.Stdout}
+}
+
+func (n *WriterNotifier) Notify(a AlertNotification) error {
+	_, err := fmt.Fprintln(n.W, a.String())
+	return err
+}
+
+// WebhookNotifier posts each notification as JSON to URL.
+type WebhookNotifier struct {
+	URL    string
+	Header http.Header
+
+	httpClient *http.Client
+}
+
+// NewWebhookNotifier returns a notifier posting to url with httpClient,
+// or http.DefaultClient if it is nil.
+func NewWebhookNotifier(url string, httpClient *http.Client) *WebhookNotifier {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &WebhookNotifier{
+		URL:        url,
+		Header:     http.Header{},
+		httpClient: httpClient,
+	}
+}
+
+func (n *WebhookNotifier) Notify(a AlertNotification) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range n.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook: non-2xx response; got %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier emails each notification through an SMTP relay.
+type SMTPNotifier struct {
+	// Addr is the host:port of the relay.
+	Addr string
+	// Auth may be nil for relays that accept unauthenticated mail.
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func (n *SMTPNotifier) Notify(a AlertNotification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", a.String())
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Notified.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Rule:    %s\r\n", a.Rule)
+	fmt.Fprintf(&msg, "Service: %s\r\n", a.Service)
+	fmt.Fprintf(&msg, "Metric:  %s\r\n", a.Metric)
+	fmt.Fprintf(&msg, "Value:   %g\r\n", a.Value)
+	fmt.Fprintf(&msg, "Since:   %s\r\n", a.Since.Format(time.RFC3339))
+	return smtp.SendMail(n.Addr, n.Auth, n.From, n.To, msg.Bytes())
+}
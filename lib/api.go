@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 )
 
 type ConfigPersister interface {
@@ -44,10 +46,22 @@ type Client struct {
 	Logs           *LogResource
 	Metrics        *MetricResource
 	ScheduledTasks *ScheduledTaskResource
+	Tasks          *TaskResource
 
 	logHTTP bool
+
+	secrets SecretBackend
+
+	observersMu sync.Mutex
+	observers   []RequestObserver
 }
 
+// RequestObserver is called after every API request sent by a Client
+// with the request method and URL, the response status (zero if no
+// response was received), how long the round trip took and any
+// transport error.
+type RequestObserver func(method string, url *url.URL, status int, elapsed time.Duration, err error)
+
 func NewClient(cfg *Config, httpClient *http.Client) *Client {
 	c := &Client{
 		cfg:        cfg,
@@ -65,6 +79,30 @@ func (c *Client) EnableHTTPLogging(value bool) {
 	c.logHTTP = value
 }
 
+// AddRequestObserver registers fn to be told about every API request.
+// It is safe to call while requests are in flight.
+func (c *Client) AddRequestObserver(fn RequestObserver) {
+	c.observersMu.Lock()
+	defer c.observersMu.Unlock()
+	// copy on write, so observeRequest can range over its copy unlocked
+	observers := make([]RequestObserver, len(c.observers), len(c.observers)+1)
+	copy(observers, c.observers)
+	c.observers = append(observers, fn)
+}
+
+func (c *Client) observeRequest(method string, url *url.URL, resp *http.Response, elapsed time.Duration, err error) {
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	c.observersMu.Lock()
+	observers := c.observers
+	c.observersMu.Unlock()
+	for _, fn := range observers {
+		fn(method, url, status, elapsed, err)
+	}
+}
+
 func (c *Client) attachResources() {
 	c.ResourceGroups = &ResourceGroupResource{client: c}
 	c.Sites = &SiteResource{client: c}
@@ -78,6 +116,7 @@ func (c *Client) attachResources() {
 	c.Logs = &LogResource{client: c}
 	c.Metrics = &MetricResource{client: c}
 	c.ScheduledTasks = &ScheduledTaskResource{client: c}
+	c.Tasks = &TaskResource{client: c}
 }
 
 func (c *Client) buildBaseURL(endpoint string) *url.URL {
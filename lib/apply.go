@@ -0,0 +1,672 @@
+package gondor
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// PlanAction is what a plan step does to a resource.
+type PlanAction string
+
+const (
+	PlanCreate PlanAction = "create"
+	PlanUpdate PlanAction = "update"
+	PlanDelete PlanAction = "delete"
+)
+
+var planActionSigils = map[PlanAction]string{
+	PlanCreate: "+",
+	PlanUpdate: "~",
+	PlanDelete: "-",
+}
+
+// PlanChange is a single field changed by a plan step.
+type PlanChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// PlanStep is one API change computed by PlanManifest.
+type PlanStep struct {
+	Action PlanAction
+	// Resource is the kind of resource changed: site, instance, service,
+	// env, host or scheduled_task.
+	Resource string
+	// Path locates the resource within the manifest, e.g. "prod/web".
+	Path    string
+	Changes []PlanChange
+
+	run func() error
+}
+
+// Plan is an ordered list of changes bringing a site in line with a
+// manifest. Parents are created before their children and deletions run
+// last, children first.
+type Plan struct {
+	Steps []*PlanStep
+}
+
+// Empty reports whether the site already matches the manifest.
+func (p *Plan) Empty() bool {
+	return len(p.Steps) == 0
+}
+
+// String renders the plan as a human-readable diff. Environment variable
+// values are never included.
+func (p *Plan) String() string {
+	var buf bytes.Buffer
+	for _, step := range p.Steps {
+		fmt.Fprintf(&buf, "%s %s %s\n", planActionSigils[step.Action], step.Resource, step.Path)
+		for _, c := range step.Changes {
+			switch {
+			case step.Action == PlanCreate:
+				fmt.Fprintf(&buf, "    %s: %s\n", c.Field, c.New)
+			case step.Action == PlanDelete:
+				fmt.Fprintf(&buf, "    %s: %s\n", c.Field, c.Old)
+			default:
+				fmt.Fprintf(&buf, "    %s: %s -> %s\n", c.Field, c.Old, c.New)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// Execute applies every step in order, stopping at the first failure.
+func (p *Plan) Execute() error {
+	for _, step := range p.Steps {
+		if err := step.run(); err != nil {
+			return fmt.Errorf("apply: %s %s %s: %s", step.Action, step.Resource, step.Path, err)
+		}
+	}
+	return nil
+}
+
+// ApplyOpts controls PlanManifest and Apply.
+type ApplyOpts struct {
+	// Prune deletes instances, services, env vars, hosts and scheduled
+	// tasks that exist on the site but not in the manifest.
+	Prune bool
+}
+
+// Apply plans the changes needed to bring the site described by m in
+// line with it and executes them. The plan is returned even if
+// execution fails part way; applying again resumes from the new state.
+func (c *Client) Apply(m *Manifest, opts ApplyOpts) (*Plan, error) {
+	plan, err := c.PlanManifest(m, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plan, plan.Execute()
+}
+
+// PlanManifest compares m against the live state of its site and returns
+// the ordered changes needed to match it, without making any.
+func (c *Client) PlanManifest(m *Manifest, opts ApplyOpts) (*Plan, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	p := &planner{client: c, opts: opts, plan: &Plan{}}
+	if err := p.planSite(m); err != nil {
+		return nil, err
+	}
+	p.plan.Steps = append(p.plan.Steps, p.deletes...)
+	return p.plan, nil
+}
+
+// urlRef holds the URL of a resource that may only exist once an
+// earlier step has run.
+type urlRef struct {
+	url *string
+}
+
+type planner struct {
+	client *Client
+	opts   ApplyOpts
+	plan   *Plan
+	// deletes run after every create and update, children first
+	deletes []*PlanStep
+
+	resourceGroupURL *string
+	keyPairs         map[string]string
+}
+
+func (p *planner) add(step *PlanStep) {
+	p.plan.Steps = append(p.plan.Steps, step)
+}
+
+func (p *planner) addDelete(step *PlanStep) {
+	if p.opts.Prune {
+		p.deletes = append(p.deletes, step)
+	}
+}
+
+func (p *planner) planSite(m *Manifest) error {
+	c := p.client
+	if m.ResourceGroup != "" {
+		rg, err := c.ResourceGroups.GetByName(m.ResourceGroup)
+		if err != nil {
+			return err
+		}
+		p.resourceGroupURL = rg.URL
+	}
+	sites, err := c.Sites.List(p.resourceGroupURL)
+	if err != nil {
+		return err
+	}
+	site := &urlRef{}
+	for _, s := range sites {
+		if stringValue(s.Name) == m.Site {
+			site.url = s.URL
+		}
+	}
+	if site.url == nil {
+		name := m.Site
+		p.add(&PlanStep{
+			Action:   PlanCreate,
+			Resource: "site",
+			Path:     name,
+			run: func() error {
+				s := &Site{Name: &name, ResourceGroup: p.resourceGroupURL}
+				if err := c.Sites.Create(s); err != nil {
+					return err
+				}
+				site.url = s.URL
+				return nil
+			},
+		})
+	}
+	var siteEnv []*EnvironmentVariable
+	if site.url != nil {
+		if siteEnv, err = c.EnvVars.ListBySite(*site.url); err != nil {
+			return err
+		}
+	}
+	err = p.planEnv(m.Site, m.Env, siteEnv, func() EnvironmentVariable {
+		return EnvironmentVariable{Site: site.url}
+	})
+	if err != nil {
+		return err
+	}
+	var existing []*Instance
+	if site.url != nil {
+		if existing, err = c.Instances.List(site.url); err != nil {
+			return err
+		}
+	}
+	byLabel := make(map[string]*Instance)
+	for _, inst := range existing {
+		byLabel[stringValue(inst.Label)] = inst
+	}
+	for _, mi := range m.Instances {
+		if err := p.planInstance(site, mi, byLabel[mi.Label]); err != nil {
+			return err
+		}
+		delete(byLabel, mi.Label)
+	}
+	for _, label := range sortedInstanceLabels(byLabel) {
+		inst := byLabel[label]
+		p.addDelete(&PlanStep{
+			Action:   PlanDelete,
+			Resource: "instance",
+			Path:     label,
+			run: func() error {
+				return c.Instances.Delete(*inst.URL)
+			},
+		})
+	}
+	return nil
+}
+
+func (p *planner) planInstance(site *urlRef, mi ManifestInstance, actual *Instance) error {
+	c := p.client
+	instance := &urlRef{}
+	if actual == nil {
+		mi := mi
+		step := &PlanStep{
+			Action:   PlanCreate,
+			Resource: "instance",
+			Path:     mi.Label,
+			run: func() error {
+				inst := &Instance{Site: site.url, Label: &mi.Label}
+				if mi.Kind != "" {
+					inst.Kind = &mi.Kind
+				}
+				if err := c.Instances.Create(inst); err != nil {
+					return err
+				}
+				instance.url = inst.URL
+				return nil
+			},
+		}
+		if mi.Kind != "" {
+			step.Changes = []PlanChange{{Field: "kind", New: mi.Kind}}
+		}
+		p.add(step)
+	} else {
+		if mi.Kind != "" && stringValue(actual.Kind) != mi.Kind {
+			return fmt.Errorf("instance %q: kind cannot be changed from %q to %q", mi.Label, stringValue(actual.Kind), mi.Kind)
+		}
+		instance.url = actual.URL
+	}
+
+	var (
+		env      []*EnvironmentVariable
+		services []*Service
+		hosts    []*HostName
+		tasks    []*ScheduledTask
+		err      error
+	)
+	if instance.url != nil {
+		if env, err = c.EnvVars.ListByInstance(*instance.url); err != nil {
+			return err
+		}
+		if services, err = c.Services.List(instance.url); err != nil {
+			return err
+		}
+		if hosts, err = c.HostNames.List(instance.url); err != nil {
+			return err
+		}
+		if tasks, err = c.ScheduledTasks.List(instance.url); err != nil {
+			return err
+		}
+	}
+	err = p.planEnv(mi.Label, mi.Env, env, func() EnvironmentVariable {
+		return EnvironmentVariable{Instance: instance.url}
+	})
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*Service)
+	for _, s := range services {
+		byName[stringValue(s.Name)] = s
+	}
+	for _, ms := range mi.Services {
+		if err := p.planService(mi.Label, instance, ms, byName[ms.Name]); err != nil {
+			return err
+		}
+		delete(byName, ms.Name)
+	}
+	for _, s := range services {
+		if _, stale := byName[stringValue(s.Name)]; !stale {
+			continue
+		}
+		s := s
+		p.addDelete(&PlanStep{
+			Action:   PlanDelete,
+			Resource: "service",
+			Path:     mi.Label + "/" + stringValue(s.Name),
+			run: func() error {
+				return c.Services.Delete(*s.URL)
+			},
+		})
+	}
+	if err := p.planHosts(mi, instance, hosts); err != nil {
+		return err
+	}
+	p.planScheduledTasks(mi, instance, tasks)
+	return nil
+}
+
+func (p *planner) planService(label string, instance *urlRef, ms ManifestService, actual *Service) error {
+	c := p.client
+	path := label + "/" + ms.Name
+	service := &urlRef{}
+	if actual == nil {
+		step := &PlanStep{
+			Action:   PlanCreate,
+			Resource: "service",
+			Path:     path,
+			Changes:  []PlanChange{{Field: "kind", New: ms.Kind}},
+			run: func() error {
+				s := ms.service()
+				s.Instance = instance.url
+				if err := c.Services.Create(s); err != nil {
+					return err
+				}
+				service.url = s.URL
+				return nil
+			},
+		}
+		if ms.Size != "" {
+			step.Changes = append(step.Changes, PlanChange{Field: "size", New: ms.Size})
+		}
+		if ms.Replicas != nil {
+			step.Changes = append(step.Changes, PlanChange{Field: "replicas", New: strconv.Itoa(*ms.Replicas)})
+		}
+		p.add(step)
+	} else {
+		if stringValue(actual.Kind) != ms.Kind {
+			return fmt.Errorf("service %q: kind cannot be changed from %q to %q", path, stringValue(actual.Kind), ms.Kind)
+		}
+		// version and open ports are only accepted on create; they are
+		// compared when the API reports them
+		if ms.Version != "" && actual.Version != nil && *actual.Version != ms.Version {
+			return fmt.Errorf("service %q: version cannot be changed from %q to %q", path, *actual.Version, ms.Version)
+		}
+		if ms.OpenPorts != "" && actual.OpenPorts != "" && actual.OpenPorts != ms.OpenPorts {
+			return fmt.Errorf("service %q: open ports cannot be changed from %q to %q", path, actual.OpenPorts, ms.OpenPorts)
+		}
+		service.url = actual.URL
+		update := Service{URL: actual.URL}
+		var changes []PlanChange
+		if ms.Size != "" && stringValue(actual.Size) != ms.Size {
+			size := ms.Size
+			update.Size = &size
+			changes = append(changes, PlanChange{Field: "size", Old: stringValue(actual.Size), New: ms.Size})
+		}
+		current := 0
+		if actual.Replicas != nil {
+			current = *actual.Replicas
+		}
+		if ms.Replicas != nil && current != *ms.Replicas {
+			n := *ms.Replicas
+			update.DesiredReplicas = &n
+			changes = append(changes, PlanChange{Field: "replicas", Old: strconv.Itoa(current), New: strconv.Itoa(n)})
+		}
+		if len(changes) > 0 {
+			p.add(&PlanStep{
+				Action:   PlanUpdate,
+				Resource: "service",
+				Path:     path,
+				Changes:  changes,
+				run: func() error {
+					return c.Services.Update(update)
+				},
+			})
+		}
+	}
+	var env []*EnvironmentVariable
+	if service.url != nil {
+		var err error
+		if env, err = c.EnvVars.ListByService(*service.url); err != nil {
+			return err
+		}
+	}
+	return p.planEnv(path, ms.Env, env, func() EnvironmentVariable {
+		return EnvironmentVariable{Service: service.url}
+	})
+}
+
+// planEnv diffs desired against actual for one scope. scope is called at
+// execution time so it sees URLs of resources created by earlier steps.
+// Keys set to ManifestSecretPlaceholder keep their current value.
+func (p *planner) planEnv(path string, desired map[string]string, actual []*EnvironmentVariable, scope func() EnvironmentVariable) error {
+	c := p.client
+	current := make(map[string]*EnvironmentVariable)
+	for _, ev := range actual {
+		current[stringValue(ev.Key)] = ev
+	}
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		key, value := key, desired[key]
+		ev, exists := current[key]
+		delete(current, key)
+		if value == ManifestSecretPlaceholder {
+			if !exists {
+				return fmt.Errorf("env %q: placeholder must be replaced with a value", path+"/"+key)
+			}
+			continue
+		}
+		// compare resolved secrets so unchanged ones are not resent
+		value, err := c.resolveSecret(value)
+		if err != nil {
+			return fmt.Errorf("env %q: %s", path+"/"+key, err)
+		}
+		if !exists {
+			p.add(&PlanStep{
+				Action:   PlanCreate,
+				Resource: "env",
+				Path:     path + "/" + key,
+				run: func() error {
+					ev := scope()
+					ev.Key, ev.Value = &key, &value
+					return c.EnvVars.Create([]*EnvironmentVariable{&ev})
+				},
+			})
+			continue
+		}
+		if stringValue(ev.Value) == value {
+			continue
+		}
+		p.add(&PlanStep{
+			Action:   PlanUpdate,
+			Resource: "env",
+			Path:     path + "/" + key,
+			Changes:  []PlanChange{{Field: "value", Old: "(hidden)", New: "(hidden)"}},
+			run: func() error {
+				return c.EnvVars.Update(EnvironmentVariable{URL: ev.URL, Value: &value})
+			},
+		})
+	}
+	stale := make([]string, 0, len(current))
+	for k := range current {
+		stale = append(stale, k)
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		ev := current[key]
+		p.addDelete(&PlanStep{
+			Action:   PlanDelete,
+			Resource: "env",
+			Path:     path + "/" + key,
+			run: func() error {
+				return c.EnvVars.Delete(*ev.URL)
+			},
+		})
+	}
+	return nil
+}
+
+// loadKeyPairs fetches the keypairs of the site's resource group once.
+func (p *planner) loadKeyPairs() error {
+	if p.keyPairs != nil {
+		return nil
+	}
+	keyPairs, err := p.client.KeyPairs.List(p.resourceGroupURL)
+	if err != nil {
+		return err
+	}
+	p.keyPairs = make(map[string]string)
+	for _, kp := range keyPairs {
+		p.keyPairs[stringValue(kp.Name)] = stringValue(kp.URL)
+	}
+	return nil
+}
+
+// keyPairURL resolves a keypair name in the site's resource group.
+func (p *planner) keyPairURL(name string) (string, error) {
+	if err := p.loadKeyPairs(); err != nil {
+		return "", err
+	}
+	u, ok := p.keyPairs[name]
+	if !ok {
+		return "", fmt.Errorf("keypair %q was not found", name)
+	}
+	return u, nil
+}
+
+// keyPairName maps a keypair URL back to its name for display.
+func (p *planner) keyPairName(u string) (string, error) {
+	if u == "" {
+		return "", nil
+	}
+	if err := p.loadKeyPairs(); err != nil {
+		return "", err
+	}
+	for name, kpURL := range p.keyPairs {
+		if kpURL == u {
+			return name, nil
+		}
+	}
+	return u, nil
+}
+
+func (p *planner) planHosts(mi ManifestInstance, instance *urlRef, actual []*HostName) error {
+	c := p.client
+	current := make(map[string]*HostName)
+	for _, h := range actual {
+		current[stringValue(h.Host)] = h
+	}
+	for _, mh := range mi.Hosts {
+		mh := mh
+		path := mi.Label + "/" + mh.Host
+		var keyPair *string
+		if mh.KeyPair != "" {
+			u, err := p.keyPairURL(mh.KeyPair)
+			if err != nil {
+				return fmt.Errorf("host %q: %s", path, err)
+			}
+			keyPair = &u
+		}
+		h, exists := current[mh.Host]
+		delete(current, mh.Host)
+		if !exists {
+			step := &PlanStep{
+				Action:   PlanCreate,
+				Resource: "host",
+				Path:     path,
+				run: func() error {
+					return c.HostNames.Create(&HostName{Instance: instance.url, Host: &mh.Host, KeyPair: keyPair})
+				},
+			}
+			if mh.KeyPair != "" {
+				step.Changes = []PlanChange{{Field: "keypair", New: mh.KeyPair}}
+			}
+			p.add(step)
+			continue
+		}
+		if stringValue(h.KeyPair) == stringValue(keyPair) {
+			continue
+		}
+		oldName, err := p.keyPairName(stringValue(h.KeyPair))
+		if err != nil {
+			return err
+		}
+		p.add(&PlanStep{
+			Action:   PlanUpdate,
+			Resource: "host",
+			Path:     path,
+			Changes: []PlanChange{{
+				Field: "keypair",
+				Old:   oldName,
+				New:   mh.KeyPair,
+			}},
+			run: func() error {
+				if keyPair == nil {
+					return h.DetachKeyPair()
+				}
+				return c.HostNames.Update(HostName{URL: h.URL, KeyPair: keyPair})
+			},
+		})
+	}
+	for _, h := range actual {
+		if _, stale := current[stringValue(h.Host)]; !stale {
+			continue
+		}
+		h := h
+		p.addDelete(&PlanStep{
+			Action:   PlanDelete,
+			Resource: "host",
+			Path:     mi.Label + "/" + stringValue(h.Host),
+			run: func() error {
+				return c.HostNames.Delete(*h.URL)
+			},
+		})
+	}
+	return nil
+}
+
+func (p *planner) planScheduledTasks(mi ManifestInstance, instance *urlRef, actual []*ScheduledTask) {
+	c := p.client
+	current := make(map[string]*ScheduledTask)
+	for _, t := range actual {
+		current[stringValue(t.Name)] = t
+	}
+	for _, mt := range mi.ScheduledTasks {
+		mt := mt
+		path := mi.Label + "/" + mt.Name
+		create := func() error {
+			t := &ScheduledTask{
+				Instance: instance.url,
+				Name:     &mt.Name,
+				Schedule: &mt.Schedule,
+				Command:  &mt.Command,
+			}
+			if mt.Timezone != "" {
+				t.Timezone = &mt.Timezone
+			}
+			return c.ScheduledTasks.Create(t)
+		}
+		t, exists := current[mt.Name]
+		delete(current, mt.Name)
+		if !exists {
+			p.add(&PlanStep{
+				Action:   PlanCreate,
+				Resource: "scheduled_task",
+				Path:     path,
+				Changes: []PlanChange{
+					{Field: "schedule", New: mt.Schedule},
+					{Field: "command", New: mt.Command},
+				},
+				run: create,
+			})
+			continue
+		}
+		var changes []PlanChange
+		if stringValue(t.Schedule) != mt.Schedule {
+			changes = append(changes, PlanChange{Field: "schedule", Old: stringValue(t.Schedule), New: mt.Schedule})
+		}
+		if mt.Timezone != "" && stringValue(t.Timezone) != mt.Timezone {
+			changes = append(changes, PlanChange{Field: "timezone", Old: stringValue(t.Timezone), New: mt.Timezone})
+		}
+		if stringValue(t.Command) != mt.Command {
+			changes = append(changes, PlanChange{Field: "command", Old: stringValue(t.Command), New: mt.Command})
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		// scheduled tasks cannot be updated in place
+		p.add(&PlanStep{
+			Action:   PlanUpdate,
+			Resource: "scheduled_task",
+			Path:     path,
+			Changes:  changes,
+			run: func() error {
+				if err := c.ScheduledTasks.Delete(*t.URL); err != nil {
+					return err
+				}
+				return create()
+			},
+		})
+	}
+	for _, t := range actual {
+		if _, stale := current[stringValue(t.Name)]; !stale {
+			continue
+		}
+		t := t
+		p.addDelete(&PlanStep{
+			Action:   PlanDelete,
+			Resource: "scheduled_task",
+			Path:     mi.Label + "/" + stringValue(t.Name),
+			run: func() error {
+				return c.ScheduledTasks.Delete(*t.URL)
+			},
+		})
+	}
+}
+
+func sortedInstanceLabels(m map[string]*Instance) []string {
+	labels := make([]string, 0, len(m))
+	for k := range m {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	return labels
+}
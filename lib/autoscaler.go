@@ -0,0 +1,248 @@
+package gondor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// AutoscalePolicy describes how one service should be scaled.
+type AutoscalePolicy struct {
+	// ServiceURL identifies the service to scale.
+	ServiceURL string
+	// MinReplicas and MaxReplicas bound the replica count.
+	MinReplicas int
+	MaxReplicas int
+	// Metric names the metric series used to make decisions.
+	Metric string
+	// Target is the desired per-replica value of Metric.
+	Target float64
+	// Cooldown is the minimum time between two scaling actions.
+	Cooldown time.Duration
+}
+
+func (p AutoscalePolicy) validate() error {
+	switch {
+	case p.ServiceURL == "":
+		return errors.New("autoscale policy: service URL is required")
+	case p.Metric == "":
+		return errors.New("autoscale policy: metric is required")
+	case p.Target <= 0:
+		return fmt.Errorf("autoscale policy: target for %q must be positive", p.Metric)
+	case p.MinReplicas < 0:
+		return errors.New("autoscale policy: min replicas must not be negative")
+	case p.MaxReplicas < p.MinReplicas:
+		return fmt.Errorf("autoscale policy: max replicas (%d) is below min replicas (%d)", p.MaxReplicas, p.MinReplicas)
+	}
+	return nil
+}
+
+// AutoscaleAction is the outcome of evaluating a policy.
+type AutoscaleAction string
+
+const (
+	AutoscaleScaled AutoscaleAction = "scaled"
+	AutoscaleDryRun AutoscaleAction = "dry-run"
+	AutoscaleHold   AutoscaleAction = "hold"
+	AutoscaleError  AutoscaleAction = "error"
+)
+
+// AutoscaleEvent records a single autoscaler decision.
+type AutoscaleEvent struct {
+	Time         time.Time
+	ServiceURL   string
+	Metric       string
+	Value        float64
+	FromReplicas int
+	ToReplicas   int
+	Action       AutoscaleAction
+	Reason       string
+}
+
+func (e AutoscaleEvent) String() string {
+	return fmt.Sprintf(
+		"%s %s %s=%g replicas %d->%d %s: %s",
+		e.Time.Format(time.RFC3339),
+		e.ServiceURL,
+		e.Metric,
+		e.Value,
+		e.FromReplicas,
+		e.ToReplicas,
+		e.Action,
+		e.Reason,
+	)
+}
+
+// Autoscaler periodically reads service metrics and adjusts replica
+// counts according to its policies. It is meant to run as a long-lived
+// sidecar for sites without platform autoscaling.
+type Autoscaler struct {
+	// Interval between evaluations; defaults to one minute.
+	Interval time.Duration
+	// DryRun records decisions without changing any service.
+	DryRun bool
+	// MaxEvents bounds the in-memory event log; defaults to 1000.
+	MaxEvents int
+	// OnEvent, if set, is called for every recorded event.
+	OnEvent func(AutoscaleEvent)
+
+	client   *Client
+	policies []AutoscalePolicy
+
+	mu         sync.Mutex
+	events     []AutoscaleEvent
+	lastScaled map[string]time.Time
+}
+
+// NewAutoscaler returns an autoscaler for the given policies.
+func NewAutoscaler(client *Client, policies []AutoscalePolicy) (*Autoscaler, error) {
+	for _, p := range policies {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Autoscaler{
+		Interval:   time.Minute,
+		MaxEvents:  1000,
+		client:     client,
+		policies:   policies,
+		lastScaled: make(map[string]time.Time),
+	}, nil
+}
+
+// Run evaluates all policies every Interval until ctx is done.
+func (a *Autoscaler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(durationOrDefault(a.Interval, time.Minute))
+	defer ticker.Stop()
+	for {
+		a.Evaluate()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate runs a single pass over every policy.
+func (a *Autoscaler) Evaluate() {
+	for _, p := range a.policies {
+		a.record(a.evaluate(p))
+	}
+}
+
+// Events returns a copy of the recorded decisions, oldest first.
+func (a *Autoscaler) Events() []AutoscaleEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	events := make([]AutoscaleEvent, len(a.events))
+	copy(events, a.events)
+	return events
+}
+
+func (a *Autoscaler) evaluate(p AutoscalePolicy) AutoscaleEvent {
+	ev := AutoscaleEvent{
+		Time:       time.Now(),
+		ServiceURL: p.ServiceURL,
+		Metric:     p.Metric,
+		Action:     AutoscaleError,
+	}
+	service, err := a.client.Services.GetFromURL(p.ServiceURL)
+	if err != nil {
+		ev.Reason = err.Error()
+		return ev
+	}
+	if service.Replicas != nil {
+		ev.FromReplicas = *service.Replicas
+	}
+	ev.ToReplicas = ev.FromReplicas
+	series, err := a.client.Metrics.List(p.ServiceURL)
+	if err != nil {
+		ev.Reason = err.Error()
+		return ev
+	}
+	value, err := latestMetricValue(series, p.Metric)
+	if err != nil {
+		ev.Reason = err.Error()
+		return ev
+	}
+	ev.Value = value
+	ev.ToReplicas = desiredReplicas(p, ev.FromReplicas, value)
+	ev.Action = AutoscaleHold
+	if ev.ToReplicas == ev.FromReplicas {
+		ev.Reason = "within target"
+		return ev
+	}
+	a.mu.Lock()
+	last, ok := a.lastScaled[p.ServiceURL]
+	a.mu.Unlock()
+	if ok && ev.Time.Sub(last) < p.Cooldown {
+		ev.Reason = fmt.Sprintf("cooling down until %s", last.Add(p.Cooldown).Format(time.RFC3339))
+		return ev
+	}
+	ev.Reason = fmt.Sprintf("%s=%g against target %g", p.Metric, value, p.Target)
+	if a.DryRun {
+		ev.Action = AutoscaleDryRun
+		return ev
+	}
+	if err := service.SetReplicas(ev.ToReplicas); err != nil {
+		ev.Action = AutoscaleError
+		ev.Reason = err.Error()
+		return ev
+	}
+	ev.Action = AutoscaleScaled
+	a.mu.Lock()
+	a.lastScaled[p.ServiceURL] = ev.Time
+	a.mu.Unlock()
+	return ev
+}
+
+func (a *Autoscaler) record(ev AutoscaleEvent) {
+	a.mu.Lock()
+	a.events = append(a.events, ev)
+	if a.MaxEvents > 0 && len(a.events) > a.MaxEvents {
+		a.events = a.events[len(a.events)-a.MaxEvents:]
+	}
+	a.mu.Unlock()
+	if a.OnEvent != nil {
+		a.OnEvent(ev)
+	}
+}
+
+// desiredReplicas scales the current replica count by the ratio of the
+// observed per-replica value to the target, clamped to the policy bounds.
+func desiredReplicas(p AutoscalePolicy, current int, value float64) int {
+	base := current
+	if base < 1 {
+		base = 1
+	}
+	n := int(math.Ceil(float64(base) * value / p.Target))
+	if n < p.MinReplicas {
+		n = p.MinReplicas
+	}
+	if n > p.MaxReplicas {
+		n = p.MaxReplicas
+	}
+	return n
+}
+
+// latestMetricValue returns the most recent value of the named series.
+func latestMetricValue(series []*MetricSeries, name string) (float64, error) {
+	for _, s := range series {
+		if s.Name == nil || *s.Name != name {
+			continue
+		}
+		points, err := s.MetricPoints()
+		if err != nil {
+			return 0, err
+		}
+		if len(points) == 0 {
+			return 0, fmt.Errorf("metric %q has no points", name)
+		}
+		return points[len(points)-1].Value, nil
+	}
+	return 0, fmt.Errorf("metric %q not found", name)
+}
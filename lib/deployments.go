@@ -52,10 +52,10 @@ func (d *Deployment) Wait() error {
 		if err != nil {
 			return false, err
 		}
-		switch *service.State {
-		case "running":
+		switch ServiceState(*service.State) {
+		case ServiceRunning:
 			return true, nil
-		case "deploying":
+		case ServiceDeploying:
 			return false, nil
 		default:
 			return false, errors.New("unknown instance state")
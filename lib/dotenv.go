@@ -0,0 +1,378 @@
+package gondor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// ParseDotenv reads environment variables in dotenv syntax:
+//
+//	# comments and blank lines are ignored
+//	export KEY=value     # "export" is optional, trailing comments allowed
+//	KEY="double quoted\nwith escapes and
+//	multiple lines"
+//	KEY='single quoted, taken literally'
+//
+// Variable references such as ${OTHER} are not expanded.
+func ParseDotenv(r io.Reader) (map[string]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &dotenvParser{src: string(b), line: 1}
+	env := make(map[string]string)
+	for {
+		key, value, ok, err := p.next()
+		if err != nil {
+			return nil, fmt.Errorf("dotenv: line %d: %s", p.start, err)
+		}
+		if !ok {
+			return env, nil
+		}
+		env[key] = value
+	}
+}
+
+type dotenvParser struct {
+	src  string
+	pos  int
+	line int
+	// start is the line the current assignment began on
+	start int
+}
+
+func (p *dotenvParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *dotenvParser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *dotenvParser) advance() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *dotenvParser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// endLine consumes the rest of the line, which may only hold whitespace
+// and a comment.
+func (p *dotenvParser) endLine() error {
+	p.skipSpaces()
+	if !p.eof() && p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if !p.eof() && p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.peek())
+	}
+	p.advance()
+	return nil
+}
+
+// next parses the next assignment, skipping blank and comment lines.
+func (p *dotenvParser) next() (key, value string, ok bool, err error) {
+	for {
+		p.start = p.line
+		p.skipSpaces()
+		if p.eof() {
+			return "", "", false, nil
+		}
+		if c := p.peek(); c == '\n' || c == '\r' || c == '#' {
+			if err := p.endLine(); err != nil {
+				return "", "", false, err
+			}
+			continue
+		}
+		break
+	}
+	if strings.HasPrefix(p.src[p.pos:], "export ") || strings.HasPrefix(p.src[p.pos:], "export\t") {
+		p.pos += len("export")
+		p.skipSpaces()
+	}
+	start := p.pos
+	for !p.eof() && isDotenvKeyByte(p.peek(), p.pos == start) {
+		p.pos++
+	}
+	key = p.src[start:p.pos]
+	if key == "" {
+		return "", "", false, fmt.Errorf("expected a variable name")
+	}
+	p.skipSpaces()
+	if p.eof() || p.peek() != '=' {
+		return "", "", false, fmt.Errorf("expected '=' after %s", key)
+	}
+	p.pos++
+	p.skipSpaces()
+	if p.eof() {
+		return key, "", true, nil
+	}
+	switch p.peek() {
+	case '"':
+		value, err = p.doubleQuoted()
+	case '\'':
+		value, err = p.singleQuoted()
+	default:
+		value = p.unquoted()
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	if err := p.endLine(); err != nil {
+		return "", "", false, err
+	}
+	return key, value, true, nil
+}
+
+func isDotenvKeyByte(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '.', c == '-':
+		return !first
+	}
+	return false
+}
+
+func (p *dotenvParser) unquoted() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		// a comment must be preceded by whitespace so values like
+		// URL fragments keep their '#'
+		if p.peek() == '#' && p.pos > start && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+			break
+		}
+		p.pos++
+	}
+	return strings.TrimRight(p.src[start:p.pos], " \t\r")
+}
+
+func (p *dotenvParser) singleQuoted() (string, error) {
+	p.advance()
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		p.advance()
+	}
+	if p.eof() {
+		return "", fmt.Errorf("unterminated single quoted value")
+	}
+	value := p.src[start:p.pos]
+	p.advance()
+	return value, nil
+}
+
+func (p *dotenvParser) doubleQuoted() (string, error) {
+	p.advance()
+	var buf bytes.Buffer
+	for !p.eof() {
+		c := p.advance()
+		switch c {
+		case '"':
+			return buf.String(), nil
+		case '\\':
+			if p.eof() {
+				break
+			}
+			switch e := p.advance(); e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case '"', '\\', '$', '`':
+				buf.WriteByte(e)
+			case '\n':
+				// line continuation
+			default:
+				buf.WriteByte('\\')
+				buf.WriteByte(e)
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated double quoted value")
+}
+
+// WriteDotenv writes env in dotenv syntax, sorted by key. Values are
+// double quoted whenever they hold anything a shell or ParseDotenv would
+// interpret.
+func WriteDotenv(w io.Writer, env map[string]string) error {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s=%s\n", k, quoteDotenvValue(env[k]))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func quoteDotenvValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\r\n#\"'\\$`=") {
+		return v
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '"', '\\', '$', '`':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// EnvDiff lists the keys an import would add, change or remove.
+type EnvDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+
+	old map[string]string
+	new map[string]string
+}
+
+// DiffEnv compares the current variables of a scope with desired ones.
+func DiffEnv(current, desired map[string]string) *EnvDiff {
+	d := &EnvDiff{old: current, new: desired}
+	for k, v := range desired {
+		old, ok := current[k]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, k)
+		case old != v:
+			d.Changed = append(d.Changed, k)
+		}
+	}
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Changed)
+	sort.Strings(d.Removed)
+	return d
+}
+
+// Empty reports whether there is nothing to change.
+func (d *EnvDiff) Empty() bool {
+	return len(d.Added)+len(d.Changed)+len(d.Removed) == 0
+}
+
+// String renders the diff without values.
+func (d *EnvDiff) String() string {
+	return d.Format(false)
+}
+
+// Format renders the diff one key per line, including the values only
+// if showValues is set.
+func (d *EnvDiff) Format(showValues bool) string {
+	var buf bytes.Buffer
+	for _, k := range d.Added {
+		if showValues {
+			fmt.Fprintf(&buf, "+ %s=%s\n", k, quoteDotenvValue(d.new[k]))
+		} else {
+			fmt.Fprintf(&buf, "+ %s\n", k)
+		}
+	}
+	for _, k := range d.Changed {
+		if showValues {
+			fmt.Fprintf(&buf, "~ %s: %s -> %s\n", k, quoteDotenvValue(d.old[k]), quoteDotenvValue(d.new[k]))
+		} else {
+			fmt.Fprintf(&buf, "~ %s\n", k)
+		}
+	}
+	for _, k := range d.Removed {
+		fmt.Fprintf(&buf, "- %s\n", k)
+	}
+	return buf.String()
+}
+
+// DotenvImportOpts controls ImportDotenv.
+type DotenvImportOpts struct {
+	// DryRun computes the diff without changing anything.
+	DryRun bool
+	// Prune removes variables on the scope that are not in the file.
+	Prune bool
+}
+
+// ImportDotenv makes scope define the variables in the dotenv document
+// read from rd and returns what changed, or with DryRun, what would.
+func (r *EnvironmentVariableResource) ImportDotenv(scope EnvScope, rd io.Reader, opts DotenvImportOpts) (*EnvDiff, error) {
+	desired, err := ParseDotenv(rd)
+	if err != nil {
+		return nil, err
+	}
+	envVars, err := r.List(scope)
+	if err != nil {
+		return nil, err
+	}
+	// diff against resolved secrets so unchanged ones do not show up
+	resolved := make(map[string]string, len(desired))
+	for k, v := range desired {
+		if resolved[k], err = r.client.resolveSecret(v); err != nil {
+			return nil, fmt.Errorf("environment variable %q: %s", k, err)
+		}
+	}
+	diff := DiffEnv(envVarMap(envVars), resolved)
+	if !opts.Prune {
+		diff.Removed = nil
+	}
+	if opts.DryRun || diff.Empty() {
+		return diff, nil
+	}
+	return diff, r.Set(scope, desired, diff.Removed)
+}
+
+// ExportDotenv writes the variables defined on scope in dotenv syntax.
+func (r *EnvironmentVariableResource) ExportDotenv(scope EnvScope, w io.Writer) error {
+	envVars, err := r.List(scope)
+	if err != nil {
+		return err
+	}
+	return WriteDotenv(w, envVarMap(envVars))
+}
+
+func envVarMap(envVars []*EnvironmentVariable) map[string]string {
+	m := make(map[string]string, len(envVars))
+	for _, ev := range envVars {
+		m[stringValue(ev.Key)] = stringValue(ev.Value)
+	}
+	return m
+}
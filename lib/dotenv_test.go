@@ -0,0 +1,118 @@
+package gondor
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"blank lines and comments", "\n  \n# comment\n  # indented comment\n", map[string]string{}},
+		{"plain", "A=1\nB=two words\n", map[string]string{"A": "1", "B": "two words"}},
+		{"no trailing newline", "A=1", map[string]string{"A": "1"}},
+		{"empty value", "A=\nB=", map[string]string{"A": "", "B": ""}},
+		{"spaces around", "  A = value  \n", map[string]string{"A": "value"}},
+		{"key characters", "A_1.b-c=x\n", map[string]string{"A_1.b-c": "x"}},
+		{"export", "export A=1\nexport\tB=2\n", map[string]string{"A": "1", "B": "2"}},
+		{"export as key", "export=1\n", map[string]string{"export": "1"}},
+		{"inline comment", "A=value # comment\nB=value\t# comment\n", map[string]string{"A": "value", "B": "value"}},
+		{"hash without space", "A=http://example.com/#top\nB=a#b\n", map[string]string{"A": "http://example.com/#top", "B": "a#b"}},
+		{"equals in value", "A=b=c\n", map[string]string{"A": "b=c"}},
+		{"single quoted", "A='literal $HOME \\n # not a comment'\n", map[string]string{"A": `literal $HOME \n # not a comment`}},
+		{"single quoted multiline", "A='one\ntwo'\n", map[string]string{"A": "one\ntwo"}},
+		{"double quoted", `A="with # hash"`, map[string]string{"A": "with # hash"}},
+		{"double quoted escapes", `A="a\nb\rc\td\"e\\f\$g\` + "`" + `h"`, map[string]string{"A": "a\nb\rc\td\"e\\f$g`h"}},
+		{"unknown escape kept", `A="\q"`, map[string]string{"A": `\q`}},
+		{"double quoted multiline", "A=\"one\ntwo\"\nB=3\n", map[string]string{"A": "one\ntwo", "B": "3"}},
+		{"line continuation", "A=\"one \\\ntwo\"\n", map[string]string{"A": "one two"}},
+		{"quoted then comment", "A=\"x\" # comment\nB='y'  # comment\n", map[string]string{"A": "x", "B": "y"}},
+		{"empty quotes", "A=\"\"\nB=''\n", map[string]string{"A": "", "B": ""}},
+		{"CRLF", "A=1\r\nB=\"2\"\r\n# c\r\n", map[string]string{"A": "1", "B": "2"}},
+		{"last wins", "A=1\nA=2\n", map[string]string{"A": "2"}},
+		{"no expansion", "A=${B}\n", map[string]string{"A": "${B}"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseDotenv(strings.NewReader(tt.src))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"=1\n", "line 1: expected a variable name"},
+		{"1A=1\n", "line 1: expected a variable name"},
+		{"A=1\nB\n", "line 2: expected '=' after B"},
+		{"A=1\n\nB: 2\n", "line 3: expected '=' after B"},
+		{"A=\"unterminated\nB=2\n", "line 1: unterminated double quoted value"},
+		{"A=1\nB='unterminated\n", "line 2: unterminated single quoted value"},
+		{"A=\"x\"y\n", `line 1: unexpected 'y' after value`},
+		{"A=\"multi\nline\"\nB=\"x\" y\n", `line 3: unexpected 'y' after value`},
+	}
+	for _, tt := range tests {
+		_, err := ParseDotenv(strings.NewReader(tt.src))
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.src)
+			continue
+		}
+		if want := "dotenv: " + tt.want; err.Error() != want {
+			t.Errorf("%q: got error %q, want %q", tt.src, err, want)
+		}
+	}
+}
+
+func TestWriteDotenvRoundTrip(t *testing.T) {
+	env := map[string]string{
+		"PLAIN":     "value",
+		"EMPTY":     "",
+		"SPACES":    "two words",
+		"LEADING":   "  padded  ",
+		"HASH":      "a # b",
+		"FRAGMENT":  "http://example.com/#top",
+		"QUOTES":    `it's "quoted"`,
+		"BACKSLASH": `C:\path\n`,
+		"DOLLAR":    "$HOME ${PATH} `cmd`",
+		"EQUALS":    "a=b",
+		"MULTILINE": "one\ntwo\r\nthree",
+		"TAB":       "a\tb",
+		"UNICODE":   "héllo wörld",
+	}
+	var buf bytes.Buffer
+	if err := WriteDotenv(&buf, env); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseDotenv(&buf)
+	if err != nil {
+		t.Fatalf("parsing written dotenv: %s", err)
+	}
+	if !reflect.DeepEqual(got, env) {
+		t.Errorf("round trip changed values:\ngot  %q\nwant %q", got, env)
+	}
+}
+
+func TestWriteDotenv(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteDotenv(&buf, map[string]string{"B": "two words", "A": "1", "C": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "A=1\nB=\"two words\"\nC=\"\"\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,127 @@
+package gondor
+
+import "sort"
+
+// EnvSource is where an environment variable definition comes from.
+type EnvSource string
+
+// Sources in increasing order of precedence.
+const (
+	EnvSourceSite     EnvSource = "site"
+	EnvSourceInstance EnvSource = "instance"
+	EnvSourceService  EnvSource = "service"
+	// EnvSourceServiceEnv is the Env map stored on the service itself.
+	EnvSourceServiceEnv EnvSource = "service_env"
+)
+
+// EnvDefinition is one definition of a key in one scope.
+type EnvDefinition struct {
+	Value  string
+	Source EnvSource
+	// URL of the environment variable; empty for EnvSourceServiceEnv.
+	URL string
+}
+
+// EffectiveEnvVar is the value a service's containers see for Key, with
+// the lower precedence definitions it overrides.
+type EffectiveEnvVar struct {
+	Key string
+	EnvDefinition
+	// Shadowed lists the overridden definitions, highest precedence first.
+	Shadowed []EnvDefinition
+}
+
+// EffectiveEnv is a resolved environment sorted by key.
+type EffectiveEnv []*EffectiveEnvVar
+
+// Map returns the winning value of every key.
+func (env EffectiveEnv) Map() map[string]string {
+	m := make(map[string]string, len(env))
+	for _, v := range env {
+		m[v.Key] = v.Value
+	}
+	return m
+}
+
+// Conflicts returns the variables defined in more than one scope.
+func (env EffectiveEnv) Conflicts() EffectiveEnv {
+	var conflicts EffectiveEnv
+	for _, v := range env {
+		if len(v.Shadowed) > 0 {
+			conflicts = append(conflicts, v)
+		}
+	}
+	return conflicts
+}
+
+// EffectiveEnv resolves the environment of a service by merging, in
+// increasing order of precedence, the variables of its site, its
+// instance, the service itself and finally the service's Env map.
+func (r *EnvironmentVariableResource) EffectiveEnv(serviceURL string) (EffectiveEnv, error) {
+	c := r.client
+	service, err := c.Services.GetFromURL(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := c.Instances.GetFromURL(*service.Instance)
+	if err != nil {
+		return nil, err
+	}
+	siteEnv, err := r.ListBySite(*instance.Site)
+	if err != nil {
+		return nil, err
+	}
+	instanceEnv, err := r.ListByInstance(*instance.URL)
+	if err != nil {
+		return nil, err
+	}
+	serviceEnv, err := r.ListByService(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]*EffectiveEnvVar)
+	define := func(key string, def EnvDefinition) {
+		v, ok := vars[key]
+		if !ok {
+			vars[key] = &EffectiveEnvVar{Key: key, EnvDefinition: def}
+			return
+		}
+		v.Shadowed = append([]EnvDefinition{v.EnvDefinition}, v.Shadowed...)
+		v.EnvDefinition = def
+	}
+	scopes := []struct {
+		source  EnvSource
+		envVars []*EnvironmentVariable
+	}{
+		{EnvSourceSite, siteEnv},
+		{EnvSourceInstance, instanceEnv},
+		{EnvSourceService, serviceEnv},
+	}
+	for _, scope := range scopes {
+		for _, ev := range scope.envVars {
+			define(stringValue(ev.Key), EnvDefinition{
+				Value:  stringValue(ev.Value),
+				Source: scope.source,
+				URL:    stringValue(ev.URL),
+			})
+		}
+	}
+	keys := make([]string, 0, len(service.Env))
+	for key := range service.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		define(key, EnvDefinition{Value: service.Env[key], Source: EnvSourceServiceEnv})
+	}
+
+	env := make(EffectiveEnv, 0, len(vars))
+	for _, v := range vars {
+		env = append(env, v)
+	}
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Key < env[j].Key
+	})
+	return env, nil
+}
@@ -1,6 +1,10 @@
 package gondor
 
-import "net/url"
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
 
 type EnvironmentVariableResource struct {
 	client *Client
@@ -30,12 +34,24 @@ func (r *EnvironmentVariableResource) findMany(url *url.URL) ([]*EnvironmentVari
 	return res, nil
 }
 
+// Create sends envVars in one batch. Values referring to a secret are
+// resolved through the client's secret backend first; the caller's
+// variables keep their references but receive the created URLs.
 func (r *EnvironmentVariableResource) Create(envVars []*EnvironmentVariable) error {
 	url := r.client.buildBaseURL("envvars/")
-	_, err := r.client.Post(url, envVars, &envVars)
+	send, err := r.client.resolveSecrets(envVars)
 	if err != nil {
 		return err
 	}
+	_, err = r.client.Post(url, send, &send)
+	if err != nil {
+		return err
+	}
+	for i := range envVars {
+		if i < len(send) && send[i] != envVars[i] {
+			envVars[i].URL = send[i].URL
+		}
+	}
 	return nil
 }
 
@@ -62,3 +78,158 @@ func (r *EnvironmentVariableResource) ListByService(serviceURL string) ([]*Envir
 	url.RawQuery = q.Encode()
 	return r.findMany(url)
 }
+
+// EnvScope identifies the site, instance or service environment variables
+// are defined on. Exactly one field must be set.
+type EnvScope struct {
+	Site     string
+	Instance string
+	Service  string
+}
+
+func (s EnvScope) validate() error {
+	n := 0
+	for _, v := range []string{s.Site, s.Instance, s.Service} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("env scope must name exactly one of site, instance or service")
+	}
+	return nil
+}
+
+// variable returns an environment variable bound to the scope.
+func (s EnvScope) variable(key string, value *string) *EnvironmentVariable {
+	ev := &EnvironmentVariable{Key: &key, Value: value}
+	switch {
+	case s.Site != "":
+		ev.Site = &s.Site
+	case s.Instance != "":
+		ev.Instance = &s.Instance
+	case s.Service != "":
+		ev.Service = &s.Service
+	}
+	return ev
+}
+
+// List returns the environment variables defined directly on scope.
+func (r *EnvironmentVariableResource) List(scope EnvScope) ([]*EnvironmentVariable, error) {
+	if err := scope.validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case scope.Site != "":
+		return r.ListBySite(scope.Site)
+	case scope.Instance != "":
+		return r.ListByInstance(scope.Instance)
+	}
+	return r.ListByService(scope.Service)
+}
+
+// Get returns the variable named key on scope.
+func (r *EnvironmentVariableResource) Get(scope EnvScope, key string) (*EnvironmentVariable, error) {
+	envVars, err := r.List(scope)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range envVars {
+		if stringValue(ev.Key) == key {
+			return ev, nil
+		}
+	}
+	return nil, ErrNotFound{msg: fmt.Sprintf("environment variable %q was not found", key)}
+}
+
+func (r *EnvironmentVariableResource) Update(envVar EnvironmentVariable) error {
+	if envVar.Value != nil {
+		value, err := r.client.resolveSecret(*envVar.Value)
+		if err != nil {
+			return fmt.Errorf("environment variable %q: %s", stringValue(envVar.Key), err)
+		}
+		envVar.Value = &value
+	}
+	u, _ := url.Parse(*envVar.URL)
+	envVar.URL = nil
+	_, err := r.client.Patch(u, &envVar, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *EnvironmentVariableResource) Delete(envVarURL string) error {
+	u, _ := url.Parse(envVarURL)
+	_, err := r.client.Delete(u, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteByKey deletes the variable named key on scope.
+func (r *EnvironmentVariableResource) DeleteByKey(scope EnvScope, key string) error {
+	ev, err := r.Get(scope, key)
+	if err != nil {
+		return err
+	}
+	return r.Delete(*ev.URL)
+}
+
+// Set makes scope define every key in values and none of the keys in
+// unset. Only the difference from the current variables is sent: new
+// keys are created in a single batch, changed keys are updated and unset
+// keys that exist are deleted.
+func (r *EnvironmentVariableResource) Set(scope EnvScope, values map[string]string, unset []string) error {
+	for _, key := range unset {
+		if _, ok := values[key]; ok {
+			return fmt.Errorf("environment variable %q is both set and unset", key)
+		}
+	}
+	current, err := r.List(scope)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*EnvironmentVariable, len(current))
+	for _, ev := range current {
+		byKey[stringValue(ev.Key)] = ev
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var create []*EnvironmentVariable
+	for _, key := range keys {
+		// compare resolved secrets so unchanged ones are not resent
+		value, err := r.client.resolveSecret(values[key])
+		if err != nil {
+			return fmt.Errorf("environment variable %q: %s", key, err)
+		}
+		ev, ok := byKey[key]
+		if !ok {
+			create = append(create, scope.variable(key, &value))
+			continue
+		}
+		if stringValue(ev.Value) == value {
+			continue
+		}
+		if err := r.Update(EnvironmentVariable{URL: ev.URL, Value: &value}); err != nil {
+			return err
+		}
+	}
+	if len(create) > 0 {
+		if err := r.Create(create); err != nil {
+			return err
+		}
+	}
+	for _, key := range unset {
+		if ev, ok := byKey[key]; ok {
+			if err := r.Delete(*ev.URL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -13,6 +13,36 @@ func (e ErrNotFound) Error() string {
 	return e.msg
 }
 
+// ErrNotConverged is returned when a service does not reach the state or
+// replica count requested of it.
+type ErrNotConverged struct {
+	Service         string
+	DesiredState    string
+	DesiredReplicas int
+	State           string
+	Replicas        int
+	Err             error
+}
+
+func (e ErrNotConverged) Error() string {
+	var want, got []string
+	if e.DesiredState != "" {
+		want = append(want, fmt.Sprintf("state %q", e.DesiredState))
+		got = append(got, fmt.Sprintf("state %q", e.State))
+	}
+	if e.DesiredReplicas >= 0 {
+		want = append(want, fmt.Sprintf("%d replicas", e.DesiredReplicas))
+		got = append(got, fmt.Sprintf("%d replicas", e.Replicas))
+	}
+	return fmt.Sprintf(
+		"service %q did not converge to %s (last observed %s): %s",
+		e.Service,
+		strings.Join(want, " and "),
+		strings.Join(got, " and "),
+		e.Err,
+	)
+}
+
 type APIError interface {
 	Errors() []string
 }
@@ -50,3 +80,23 @@ func (e apiError) Errors() []string {
 	}
 	return res
 }
+
+// serverError is returned for a 5xx response from the API.
+type serverError struct {
+	status int
+	msg    string
+}
+
+func (e serverError) Error() string {
+	return e.msg
+}
+
+// ErrorFields returns the field errors carried by err if it is an API
+// or validation error.
+func ErrorFields(err error) (ErrorList, bool) {
+	e, ok := err.(apiError)
+	if !ok {
+		return nil, false
+	}
+	return e.errList, true
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 // SendRequest will build an HTTP request to send to the Gondor API.
@@ -41,7 +42,9 @@ func (c *Client) SendRequest(method string, url *url.URL, payload, result interf
 	req.Header = header
 	c.logRequest(req)
 	var errList ErrorList
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
+	c.observeRequest(method, url, resp, time.Since(start), err)
 	if err != nil {
 		return nil, err
 	}
@@ -99,16 +102,19 @@ func (c *Client) SendRequest(method string, url *url.URL, payload, result interf
 				}
 				return resp, ErrNotFound{msg: errDetail.Detail}
 			case 500:
-				return resp, fmt.Errorf(
+				return resp, serverError{status: resp.StatusCode, msg: fmt.Sprintf(
 					"Internal Server Error\n%s",
 					"Our staff has been notified of this error. Please try again later.",
-				)
+				)}
 			case 502:
-				return resp, fmt.Errorf(
+				return resp, serverError{status: resp.StatusCode, msg: fmt.Sprintf(
 					"Bad Gateway\n%s",
 					"Our staff has been notified of this error. Please try again later.",
-				)
+				)}
 			default:
+				if resp.StatusCode >= 500 {
+					return resp, serverError{status: resp.StatusCode, msg: fmt.Sprintf("unknown response: %d", resp.StatusCode)}
+				}
 				return resp, fmt.Errorf("unknown response: %d", resp.StatusCode)
 			}
 		}
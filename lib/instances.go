@@ -3,6 +3,7 @@ package gondor
 import (
 	"fmt"
 	"net/url"
+	"time"
 )
 
 type InstanceResource struct {
@@ -87,3 +88,165 @@ func (r *InstanceResource) Delete(instanceURL string) error {
 	}
 	return nil
 }
+
+// WaitUntil polls the instance until it reports state, refreshing its
+// fields as it goes. A negative timeout waits forever.
+func (instance *Instance) WaitUntil(state string, timeout time.Duration) error {
+	err := pollUntil(timeout, func() (bool, error) {
+		current, err := instance.r.GetFromURL(*instance.URL)
+		if err != nil {
+			return false, err
+		}
+		*instance = *current
+		return instance.State != nil && *instance.State == state, nil
+	})
+	if err == ErrTimeout {
+		return fmt.Errorf(
+			"instance %q did not reach state %q (last observed %q)",
+			stringValue(instance.Label),
+			state,
+			stringValue(instance.State),
+		)
+	}
+	return err
+}
+
+// Clone creates a new instance on the same site labelled newLabel, with
+// the services, environment variables and scheduled tasks of instance.
+// Builds are not deployed; see Promote.
+func (instance *Instance) Clone(newLabel string) (*Instance, error) {
+	c := instance.r.client
+	clone := &Instance{
+		Site:  instance.Site,
+		Label: &newLabel,
+		Kind:  instance.Kind,
+	}
+	if err := c.Instances.Create(clone); err != nil {
+		return nil, err
+	}
+	clone.r = instance.r
+	services, err := c.Services.List(instance.URL)
+	if err != nil {
+		return clone, err
+	}
+	for _, src := range services {
+		service := &Service{
+			Instance: clone.URL,
+			Name:     src.Name,
+			Kind:     src.Kind,
+			Size:     src.Size,
+			Replicas: src.Replicas,
+			Version:  src.Version,
+			Env:      src.Env,
+		}
+		if err := c.Services.Create(service); err != nil {
+			return clone, fmt.Errorf("cloning service %q: %s", stringValue(src.Name), err)
+		}
+		envVars, err := c.EnvVars.ListByService(*src.URL)
+		if err != nil {
+			return clone, err
+		}
+		if len(envVars) > 0 {
+			if err := c.EnvVars.Create(copyEnvVars(envVars, EnvironmentVariable{Service: service.URL})); err != nil {
+				return clone, fmt.Errorf("cloning environment of service %q: %s", stringValue(src.Name), err)
+			}
+		}
+	}
+	envVars, err := c.EnvVars.ListByInstance(*instance.URL)
+	if err != nil {
+		return clone, err
+	}
+	if len(envVars) > 0 {
+		if err := c.EnvVars.Create(copyEnvVars(envVars, EnvironmentVariable{Instance: clone.URL})); err != nil {
+			return clone, fmt.Errorf("cloning instance environment: %s", err)
+		}
+	}
+	tasks, err := c.ScheduledTasks.List(instance.URL)
+	if err != nil {
+		return clone, err
+	}
+	for _, src := range tasks {
+		task := &ScheduledTask{
+			Instance: clone.URL,
+			Name:     src.Name,
+			Schedule: src.Schedule,
+			Timezone: src.Timezone,
+			Command:  src.Command,
+		}
+		if err := c.ScheduledTasks.Create(task); err != nil {
+			return clone, fmt.Errorf("cloning scheduled task %q: %s", stringValue(src.Name), err)
+		}
+	}
+	return clone, nil
+}
+
+// copyEnvVars returns copies of envVars rescoped to the site, instance or
+// service set on scope.
+func copyEnvVars(envVars []*EnvironmentVariable, scope EnvironmentVariable) []*EnvironmentVariable {
+	res := make([]*EnvironmentVariable, 0, len(envVars))
+	for _, ev := range envVars {
+		res = append(res, &EnvironmentVariable{
+			Site:     scope.Site,
+			Instance: scope.Instance,
+			Service:  scope.Service,
+			Key:      ev.Key,
+			Value:    ev.Value,
+		})
+	}
+	return res
+}
+
+// Promote deploys the builds currently running on the services of
+// instance onto the services of the same name on target. It returns the
+// deployments created; services with no deployment, or with no
+// counterpart on target, are skipped.
+func (instance *Instance) Promote(target *Instance) ([]*Deployment, error) {
+	c := instance.r.client
+	current, err := c.Deployments.List(instance.Site)
+	if err != nil {
+		return nil, err
+	}
+	// latest deployment per service URL
+	latest := make(map[string]*Deployment)
+	for _, d := range current {
+		if d.Service == nil || d.Build == nil {
+			continue
+		}
+		prev, ok := latest[*d.Service]
+		if !ok || stringValue(d.Created) > stringValue(prev.Created) {
+			latest[*d.Service] = d
+		}
+	}
+	sources, err := c.Services.List(instance.URL)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := c.Services.List(target.URL)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*Service, len(targets))
+	for _, s := range targets {
+		byName[stringValue(s.Name)] = s
+	}
+	var deployments []*Deployment
+	for _, src := range sources {
+		d, ok := latest[stringValue(src.URL)]
+		if !ok {
+			continue
+		}
+		dst, ok := byName[stringValue(src.Name)]
+		if !ok {
+			continue
+		}
+		deployment := &Deployment{
+			Service: dst.URL,
+			Build:   d.Build,
+		}
+		if err := c.Deployments.Create(deployment); err != nil {
+			return deployments, fmt.Errorf("promoting service %q: %s", stringValue(src.Name), err)
+		}
+		deployments = append(deployments, deployment)
+	}
+	return deployments, nil
+}
@@ -0,0 +1,212 @@
+package gondor
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// LogFormat names the format a structured log message was written in.
+type LogFormat string
+
+const (
+	LogFormatText     LogFormat = "text"
+	LogFormatJSON     LogFormat = "json"
+	LogFormatLogfmt   LogFormat = "logfmt"
+	LogFormatPython   LogFormat = "python"
+	LogFormatGunicorn LogFormat = "gunicorn"
+)
+
+// StructuredLog is a log message decoded by DecodeLogMessage.
+type StructuredLog struct {
+	Format  LogFormat
+	Level   LogLevel
+	Logger  string
+	Message string
+	Fields  map[string]interface{}
+}
+
+var (
+	levelKeys   = []string{"level", "levelname", "severity", "lvl"}
+	loggerKeys  = []string{"logger", "name", "logger_name"}
+	messageKeys = []string{"msg", "message", "event"}
+)
+
+// Python logging, in the default basicConfig form:
+//
+//	ERROR:django.request:Internal Server Error: /
+var pythonBasicRe = regexp.MustCompile(`^(DEBUG|INFO|WARNING|ERROR|CRITICAL):([^:\s]+):\s?(.*)$`)
+
+// Python logging with a timestamp, as used by Django's examples and most
+// application configs:
+//
+//	2016-05-01 12:00:00,123 ERROR django.request Internal Server Error: /
+//	[2016-05-01 12:00:00,123] ERROR [django.request:124] Internal Server Error: /
+var pythonTimeRe = regexp.MustCompile(`^\[?\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:[,.]\d+)?\]?\s+\[?(DEBUG|INFO|WARNING|ERROR|CRITICAL)\]?\s+\[?([\w.]+)(?::\d+)?\]?:?\s+(.*)$`)
+
+// Gunicorn's own log lines:
+//
+//	[2016-05-01 12:00:00 +0000] [12] [INFO] Booting worker with pid: 12
+var gunicornRe = regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} [+-]\d{4}\] \[(\d+)\] \[(DEBUG|INFO|WARNING|ERROR|CRITICAL)\] (.*)$`)
+
+// DecodeLogMessage recognizes JSON, logfmt and common Python log formats
+// and extracts the level, logger and fields from msg. Messages in no
+// recognized format are returned with Format set to LogFormatText.
+func DecodeLogMessage(msg string) *StructuredLog {
+	line := strings.TrimRightFunc(msg, unicode.IsSpace)
+	if s := decodeJSONLog(line); s != nil {
+		return s
+	}
+	if m := gunicornRe.FindStringSubmatch(line); m != nil {
+		level, _ := ParseLogLevel(m[2])
+		return &StructuredLog{
+			Format:  LogFormatGunicorn,
+			Level:   level,
+			Logger:  "gunicorn",
+			Message: m[3],
+			Fields:  map[string]interface{}{"pid": m[1]},
+		}
+	}
+	for _, re := range []*regexp.Regexp{pythonBasicRe, pythonTimeRe} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			level, _ := ParseLogLevel(m[1])
+			return &StructuredLog{
+				Format:  LogFormatPython,
+				Level:   level,
+				Logger:  m[2],
+				Message: m[3],
+				Fields:  map[string]interface{}{},
+			}
+		}
+	}
+	if fields, ok := parseLogfmt(line); ok {
+		s := &StructuredLog{
+			Format: LogFormatLogfmt,
+			Fields: make(map[string]interface{}, len(fields)),
+		}
+		for k, v := range fields {
+			s.Fields[k] = v
+		}
+		s.extract()
+		return s
+	}
+	return &StructuredLog{
+		Format:  LogFormatText,
+		Message: line,
+		Fields:  map[string]interface{}{},
+	}
+}
+
+func decodeJSONLog(line string) *StructuredLog {
+	if !strings.HasPrefix(line, "{") {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return nil
+	}
+	s := &StructuredLog{
+		Format: LogFormatJSON,
+		Fields: fields,
+	}
+	s.extract()
+	return s
+}
+
+// extract moves well-known keys out of Fields.
+func (s *StructuredLog) extract() {
+	if v, ok := popField(s.Fields, levelKeys); ok {
+		s.Level, _ = ParseLogLevel(v)
+	}
+	if v, ok := popField(s.Fields, loggerKeys); ok {
+		s.Logger = v
+	}
+	if v, ok := popField(s.Fields, messageKeys); ok {
+		s.Message = v
+	}
+}
+
+func popField(fields map[string]interface{}, keys []string) (string, bool) {
+	for _, k := range keys {
+		if v, ok := fields[k]; ok {
+			delete(fields, k)
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
+
+// parseLogfmt parses key=value pairs. It only succeeds if every token of
+// line is a pair, so plain text containing a stray '=' is not mistaken
+// for logfmt.
+func parseLogfmt(line string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	i := 0
+	for {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '"' {
+			i++
+		}
+		if i == start || i >= len(line) || line[i] != '=' {
+			return nil, false
+		}
+		key := line[start:i]
+		i++
+		var value string
+		if i < len(line) && line[i] == '"' {
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(line) {
+				c := line[i]
+				if c == '\\' && i+1 < len(line) {
+					switch line[i+1] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(line[i+1])
+					}
+					i += 2
+					continue
+				}
+				if c == '"' {
+					closed = true
+					i++
+					break
+				}
+				b.WriteByte(c)
+				i++
+			}
+			if !closed {
+				return nil, false
+			}
+			value = b.String()
+		} else {
+			start := i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			value = line[start:i]
+		}
+		fields[key] = value
+	}
+	if len(fields) < 2 {
+		return nil, false
+	}
+	return fields, true
+}
+
+// Structured decodes the record message. See DecodeLogMessage.
+func (rec *LogRecord) Structured() *StructuredLog {
+	return DecodeLogMessage(stringValue(rec.Message))
+}
@@ -0,0 +1,152 @@
+package gondor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeLogMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want StructuredLog
+	}{
+		{
+			name: "json",
+			msg:  `{"level":"error","logger":"app.views","msg":"payment failed","order":42}`,
+			want: StructuredLog{Format: LogFormatJSON, Level: LogLevelError, Logger: "app.views", Message: "payment failed", Fields: map[string]interface{}{"order": float64(42)}},
+		},
+		{
+			name: "json alternate keys",
+			msg:  `{"severity":"WARNING","name":"worker","message":"retrying","attempt":"2"}` + "\n",
+			want: StructuredLog{Format: LogFormatJSON, Level: LogLevelWarning, Logger: "worker", Message: "retrying", Fields: map[string]interface{}{"attempt": "2"}},
+		},
+		{
+			name: "json structlog event",
+			msg:  `{"event":"user logged in","user_id":7,"lvl":"info"}`,
+			want: StructuredLog{Format: LogFormatJSON, Level: LogLevelInfo, Message: "user logged in", Fields: map[string]interface{}{"user_id": float64(7)}},
+		},
+		{
+			name: "json without known keys",
+			msg:  `{"path":"/health","status":200}`,
+			want: StructuredLog{Format: LogFormatJSON, Fields: map[string]interface{}{"path": "/health", "status": float64(200)}},
+		},
+		{
+			name: "gunicorn",
+			msg:  "[2016-05-01 12:00:00 +0000] [12] [INFO] Booting worker with pid: 12",
+			want: StructuredLog{Format: LogFormatGunicorn, Level: LogLevelInfo, Logger: "gunicorn", Message: "Booting worker with pid: 12", Fields: map[string]interface{}{"pid": "12"}},
+		},
+		{
+			name: "gunicorn critical",
+			msg:  "[2016-05-01 12:00:05 -0400] [7] [CRITICAL] WORKER TIMEOUT (pid:13)",
+			want: StructuredLog{Format: LogFormatGunicorn, Level: LogLevelCritical, Logger: "gunicorn", Message: "WORKER TIMEOUT (pid:13)", Fields: map[string]interface{}{"pid": "7"}},
+		},
+		{
+			name: "python basicConfig",
+			msg:  "ERROR:django.request:Internal Server Error: /checkout/",
+			want: StructuredLog{Format: LogFormatPython, Level: LogLevelError, Logger: "django.request", Message: "Internal Server Error: /checkout/", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "python basicConfig root logger",
+			msg:  "WARNING:root:disk almost full",
+			want: StructuredLog{Format: LogFormatPython, Level: LogLevelWarning, Logger: "root", Message: "disk almost full", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "python with timestamp",
+			msg:  "2016-05-01 12:00:00,123 ERROR django.request Internal Server Error: /",
+			want: StructuredLog{Format: LogFormatPython, Level: LogLevelError, Logger: "django.request", Message: "Internal Server Error: /", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "python bracketed with line number",
+			msg:  "[2016-05-01 12:00:00,123] DEBUG [app.tasks:124] task started",
+			want: StructuredLog{Format: LogFormatPython, Level: LogLevelDebug, Logger: "app.tasks", Message: "task started", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "logfmt",
+			msg:  `level=warn logger=http msg="slow request" path=/api duration=1.2s`,
+			want: StructuredLog{Format: LogFormatLogfmt, Level: LogLevelWarning, Logger: "http", Message: "slow request", Fields: map[string]interface{}{"path": "/api", "duration": "1.2s"}},
+		},
+		{
+			name: "logfmt escapes",
+			msg:  `msg="line one\nsaid \"hi\"" count=3`,
+			want: StructuredLog{Format: LogFormatLogfmt, Message: "line one\nsaid \"hi\"", Fields: map[string]interface{}{"count": "3"}},
+		},
+		{
+			name: "plain text",
+			msg:  "Starting development server at http://0.0.0.0:8000/\n",
+			want: StructuredLog{Format: LogFormatText, Message: "Starting development server at http://0.0.0.0:8000/", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "text with a single pair is not logfmt",
+			msg:  "retrying with timeout=30",
+			want: StructuredLog{Format: LogFormatText, Message: "retrying with timeout=30", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "lone pair is not logfmt",
+			msg:  "debug=true",
+			want: StructuredLog{Format: LogFormatText, Message: "debug=true", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "unterminated logfmt quote",
+			msg:  `msg="oops level=info`,
+			want: StructuredLog{Format: LogFormatText, Message: `msg="oops level=info`, Fields: map[string]interface{}{}},
+		},
+		{
+			name: "invalid json",
+			msg:  `{"level": "error", "msg":`,
+			want: StructuredLog{Format: LogFormatText, Message: `{"level": "error", "msg":`, Fields: map[string]interface{}{}},
+		},
+		{
+			name: "unknown python level",
+			msg:  "NOTICE:app:something happened",
+			want: StructuredLog{Format: LogFormatText, Message: "NOTICE:app:something happened", Fields: map[string]interface{}{}},
+		},
+		{
+			name: "gunicorn access log",
+			msg:  `10.0.0.1 - - [01/May/2016:12:00:00 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/7.47.0"`,
+			want: StructuredLog{Format: LogFormatText, Message: `10.0.0.1 - - [01/May/2016:12:00:00 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/7.47.0"`, Fields: map[string]interface{}{}},
+		},
+		{
+			name: "traceback line",
+			msg:  `  File "/app/views.py", line 12, in index`,
+			want: StructuredLog{Format: LogFormatText, Message: `  File "/app/views.py", line 12, in index`, Fields: map[string]interface{}{}},
+		},
+	}
+	for _, tt := range tests {
+		got := DecodeLogMessage(tt.msg)
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestParseLogTimestamp(t *testing.T) {
+	want := time.Date(2016, 5, 1, 12, 0, 0, 123000000, time.UTC)
+	tests := []struct {
+		s  string
+		ok bool
+	}{
+		{"2016-05-01T12:00:00.123Z", true},
+		{"2016-05-01T12:00:00.123+00:00", true},
+		{"2016-05-01T14:00:00.123+0200", true},
+		{"2016-05-01T12:00:00.123", true},
+		{"2016-05-01 12:00:00", false},
+		{"yesterday", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got, err := parseLogTimestamp(tt.s)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", tt.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.s, err)
+		} else if !got.Equal(want) {
+			t.Errorf("%q: got %s, want %s", tt.s, got, want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package gondor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogExportFormat is an output format understood by LogResource.Export.
+type LogExportFormat string
+
+const (
+	LogExportNDJSON LogExportFormat = "ndjson"
+	LogExportCSV    LogExportFormat = "csv"
+	LogExportText   LogExportFormat = "text"
+)
+
+// LogExportOpts controls LogResource.Export.
+type LogExportOpts struct {
+	// LogRequestOpts selects the time range and filters. Order defaults
+	// to "asc".
+	LogRequestOpts
+
+	// Gzip compresses the output as one gzip member per page, which
+	// multistream readers such as gzip.Reader and zcat read as a whole.
+	Gzip bool
+
+	// Checkpoint, if set, is a file recording the page token of the next
+	// page to export and the size of the output up to that page. It is
+	// rewritten after every page and removed once the export completes.
+	// If it exists when Export starts, the export resumes from it: w must
+	// then be the output of the interrupted run, such as an *os.File, and
+	// is truncated back to the checkpoint so a page that was only partly
+	// written is not written twice.
+	Checkpoint string
+}
+
+type logExportCheckpoint struct {
+	PageToken string `json:"page_token"`
+	Offset    int64  `json:"offset"`
+}
+
+// truncater is an output Export can rewind when resuming.
+type truncater interface {
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// Export pages through the log of target and writes every record to w in
+// the given format. It returns the number of records written by this call.
+func (r *LogResource) Export(ctx context.Context, target LogTarget, w io.Writer, format LogExportFormat, opts LogExportOpts) (int, error) {
+	var enc logEncoder
+	switch format {
+	case LogExportNDJSON:
+		enc = ndjsonLogEncoder{}
+	case LogExportCSV:
+		enc = csvLogEncoder{}
+	case LogExportText:
+		enc = textLogEncoder{}
+	default:
+		return 0, fmt.Errorf("unknown log export format %q", format)
+	}
+	reqOpts := opts.LogRequestOpts
+	if reqOpts.Order == "" {
+		reqOpts.Order = "asc"
+	}
+	// base is where the output written by this call starts in w, so
+	// base+out.n is the size of the whole output
+	var base int64
+	resumed := false
+	if opts.Checkpoint != "" {
+		cp, err := readLogExportCheckpoint(opts.Checkpoint)
+		if err != nil {
+			return 0, err
+		}
+		t, ok := w.(truncater)
+		switch {
+		case cp != nil && !ok:
+			return 0, fmt.Errorf("log export checkpoint %s: cannot resume into an output that cannot be truncated", opts.Checkpoint)
+		case cp != nil:
+			if err := t.Truncate(cp.Offset); err != nil {
+				return 0, err
+			}
+			if _, err := t.Seek(cp.Offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+			reqOpts.PageToken = cp.PageToken
+			base = cp.Offset
+			resumed = true
+		case ok:
+			if base, err = t.Seek(0, io.SeekEnd); err != nil {
+				return 0, err
+			}
+		}
+	}
+	out := &countingWriter{w: w}
+	// With Gzip every page is written as a gzip member of its own and
+	// the member is ended before the checkpoint moves past the page, so
+	// the checkpoint always falls between members.
+	var gz *gzip.Writer
+	member := func() io.Writer {
+		if !opts.Gzip {
+			return out
+		}
+		if gz == nil {
+			gz = gzip.NewWriter(out)
+		}
+		return gz
+	}
+	endMember := func() error {
+		if gz == nil {
+			return nil
+		}
+		err := gz.Close()
+		gz = nil
+		return err
+	}
+	defer endMember()
+	checkpoint := func() error {
+		if opts.Checkpoint == "" {
+			return nil
+		}
+		cp := logExportCheckpoint{PageToken: reqOpts.PageToken, Offset: base + out.n}
+		return writeLogExportCheckpoint(opts.Checkpoint, cp)
+	}
+	if !resumed {
+		if err := enc.header(member()); err != nil {
+			return 0, err
+		}
+		if err := endMember(); err != nil {
+			return 0, err
+		}
+		if err := checkpoint(); err != nil {
+			return 0, err
+		}
+	}
+	written := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		page, err := r.List(target, reqOpts)
+		if err != nil {
+			return written, err
+		}
+		pw := member()
+		for _, rec := range page.Records {
+			if err := enc.encode(pw, rec); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if err := endMember(); err != nil {
+			return written, err
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		reqOpts.PageToken = page.NextPageToken
+		if err := checkpoint(); err != nil {
+			return written, err
+		}
+	}
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func readLogExportCheckpoint(path string) (*logExportCheckpoint, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp logExportCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("log export checkpoint %s: %s", path, err)
+	}
+	return &cp, nil
+}
+
+func writeLogExportCheckpoint(path string, cp logExportCheckpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+type logEncoder interface {
+	header(w io.Writer) error
+	encode(w io.Writer, rec *LogRecord) error
+}
+
+type ndjsonLogEncoder struct{}
+
+func (ndjsonLogEncoder) header(io.Writer) error { return nil }
+
+func (ndjsonLogEncoder) encode(w io.Writer, rec *LogRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+var logCSVColumns = []string{"id", "timestamp", "stream", "tag", "message"}
+
+type csvLogEncoder struct{}
+
+func (csvLogEncoder) header(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(logCSVColumns)
+	cw.Flush()
+	return cw.Error()
+}
+
+func (csvLogEncoder) encode(w io.Writer, rec *LogRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		stringValue(rec.ID),
+		stringValue(rec.Timestamp),
+		stringValue(rec.Stream),
+		stringValue(rec.Tag),
+		stringValue(rec.Message),
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+type textLogEncoder struct{}
+
+func (textLogEncoder) header(io.Writer) error { return nil }
+
+func (textLogEncoder) encode(w io.Writer, rec *LogRecord) error {
+	ts := stringValue(rec.Timestamp)
+	if !rec.Time.IsZero() {
+		ts = rec.Time.UTC().Format(time.RFC3339Nano)
+	}
+	prefix := fmt.Sprintf("%s [%s]", ts, valueOrDefault(stringValue(rec.Stream), "-"))
+	if rec.Tag != nil && *rec.Tag != "" {
+		prefix = fmt.Sprintf("%s %s:", prefix, *rec.Tag)
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", prefix, strings.TrimRight(stringValue(rec.Message), "\n"))
+	return err
+}
@@ -0,0 +1,163 @@
+package gondor
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// LogLevel is the severity of a log record. Levels are ordered so that a
+// filter can keep records at or above a minimum.
+type LogLevel int
+
+const (
+	LogLevelUnknown LogLevel = iota
+	LogLevelDebug
+	LogLevelInfo
+	LogLevelWarning
+	LogLevelError
+	LogLevelCritical
+)
+
+var logLevelNames = map[string]LogLevel{
+	"debug":    LogLevelDebug,
+	"trace":    LogLevelDebug,
+	"info":     LogLevelInfo,
+	"notice":   LogLevelInfo,
+	"warn":     LogLevelWarning,
+	"warning":  LogLevelWarning,
+	"error":    LogLevelError,
+	"err":      LogLevelError,
+	"critical": LogLevelCritical,
+	"crit":     LogLevelCritical,
+	"fatal":    LogLevelCritical,
+	"panic":    LogLevelCritical,
+}
+
+// ParseLogLevel returns the level named by s, ignoring case.
+func ParseLogLevel(s string) (LogLevel, error) {
+	if level, ok := logLevelNames[strings.ToLower(s)]; ok {
+		return level, nil
+	}
+	return LogLevelUnknown, fmt.Errorf("unknown log level %q", s)
+}
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarning:
+		return "warning"
+	case LogLevelError:
+		return "error"
+	case LogLevelCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// LogFilter narrows the records returned by a log query. Stream, Tag and
+// Query are evaluated by the API; Pattern and MinLevel are applied to
+// each page on the client.
+type LogFilter struct {
+	// Stream restricts records to "stdout" or "stderr".
+	Stream string
+	// Tag restricts records to a single tag.
+	Tag string
+	// Query is a free-text search.
+	Query string
+	// Pattern, if set, must match the record message.
+	Pattern *regexp.Regexp
+	// MinLevel drops records below this level, including records whose
+	// level cannot be determined.
+	MinLevel LogLevel
+}
+
+func (f LogFilter) addTo(q url.Values) error {
+	switch f.Stream {
+	case "", "stdout", "stderr":
+	default:
+		return fmt.Errorf("log stream must be stdout or stderr, not %q", f.Stream)
+	}
+	if f.Stream != "" {
+		q.Add("stream", f.Stream)
+	}
+	if f.Tag != "" {
+		q.Add("tag", f.Tag)
+	}
+	if f.Query != "" {
+		q.Add("q", f.Query)
+	}
+	return nil
+}
+
+// clientSide reports whether the filter drops records after fetching.
+func (f LogFilter) clientSide() bool {
+	return f.Pattern != nil || f.MinLevel != LogLevelUnknown
+}
+
+// Match reports whether rec passes the client-side parts of the filter.
+func (f LogFilter) Match(rec *LogRecord) bool {
+	msg := stringValue(rec.Message)
+	if f.Pattern != nil && !f.Pattern.MatchString(msg) {
+		return false
+	}
+	if f.MinLevel != LogLevelUnknown && rec.Level() < f.MinLevel {
+		return false
+	}
+	return true
+}
+
+func (f LogFilter) apply(records []*LogRecord) []*LogRecord {
+	if !f.clientSide() {
+		return records
+	}
+	kept := records[:0]
+	for _, rec := range records {
+		if f.Match(rec) {
+			kept = append(kept, rec)
+		}
+	}
+	return kept
+}
+
+var logLevelRe = regexp.MustCompile(`(?i)(?:^|[\s\[:|"=])(debug|trace|info|notice|warn|warning|error|err|critical|crit|fatal|panic)(?:$|[\s\]:|",])`)
+
+// Level makes a best effort at determining the severity of the record
+// from its message, preferring a level decoded from a structured message.
+func (rec *LogRecord) Level() LogLevel {
+	if level := rec.Structured().Level; level != LogLevelUnknown {
+		return level
+	}
+	m := logLevelRe.FindStringSubmatch(stringValue(rec.Message))
+	if m == nil {
+		return LogLevelUnknown
+	}
+	level, _ := ParseLogLevel(m[1])
+	return level
+}
+
+// Collect pages through target until at least limit records pass the
+// filter in opts, or the log is exhausted. It returns the matching
+// records and the token to resume from. A limit of zero collects every
+// page.
+func (r *LogResource) Collect(target LogTarget, opts LogRequestOpts, limit int) ([]*LogRecord, string, error) {
+	var records []*LogRecord
+	for {
+		page, err := r.List(target, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, page.Records...)
+		if limit > 0 && len(records) >= limit {
+			return records, page.NextPageToken, nil
+		}
+		if page.NextPageToken == "" {
+			return records, "", nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
@@ -0,0 +1,246 @@
+package gondor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// LogSink receives batches of log records from a LogForwarder. Write
+// must only return nil once the batch has been durably accepted; a
+// failed batch is retried in full.
+type LogSink interface {
+	Write(records []*LogRecord) error
+	Close() error
+}
+
+// LogForwarder follows a log target and delivers its records to one or
+// more sinks. With a checkpoint file it provides at-least-once delivery
+// across restarts: records are only checkpointed once every sink has
+// accepted them.
+type LogForwarder struct {
+	// Target is the log to follow.
+	Target LogTarget
+	// Sinks receive every record.
+	Sinks []LogSink
+	// Checkpoint, if set, is a file recording the last delivered position.
+	Checkpoint string
+	// BatchSize is the maximum number of records per delivery; defaults
+	// to 100.
+	BatchSize int
+	// FlushInterval bounds how long records wait for a batch to fill;
+	// defaults to one second.
+	FlushInterval time.Duration
+	// RetryInterval is the initial delay before redelivering a batch to a
+	// failing sink; it doubles up to one minute. Defaults to one second.
+	RetryInterval time.Duration
+	// TailOpts is passed through to LogResource.Tail. Since is overridden
+	// by the checkpoint, if one exists.
+	TailOpts TailOpts
+	// OnError, if set, is called with every sink error before a retry.
+	OnError func(sink LogSink, err error)
+
+	client *Client
+}
+
+// NewLogForwarder returns a forwarder for target delivering to sinks.
+func NewLogForwarder(client *Client, target LogTarget, sinks ...LogSink) *LogForwarder {
+	return &LogForwarder{
+		Target:        target,
+		Sinks:         sinks,
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		RetryInterval: time.Second,
+		client:        client,
+	}
+}
+
+type logForwardCheckpoint struct {
+	Time time.Time `json:"time"`
+	// IDs holds the records delivered at exactly Time, which the overlap
+	// of the first poll after a restart would otherwise repeat.
+	IDs []string `json:"ids"`
+	// Untimed holds the most recent records delivered without a
+	// timestamp, which cannot be placed relative to Time.
+	Untimed []string `json:"untimed,omitempty"`
+}
+
+// maxUntimedIDs bounds logForwardCheckpoint.Untimed.
+const maxUntimedIDs = 1000
+
+// Run forwards records until ctx is done or the tail fails. Sinks are
+// closed before it returns.
+func (f *LogForwarder) Run(ctx context.Context) error {
+	defer func() {
+		for _, sink := range f.Sinks {
+			sink.Close()
+		}
+	}()
+	if len(f.Sinks) == 0 {
+		return errors.New("log forwarder: no sinks configured")
+	}
+	cp, err := f.readCheckpoint()
+	if err != nil {
+		return err
+	}
+	opts := f.TailOpts
+	resume := cp
+	skip := make(map[string]bool)
+	untimed := make(map[string]bool)
+	if resume != nil {
+		since := resume.Time
+		opts.Since = &since
+		for _, id := range resume.IDs {
+			skip[id] = true
+		}
+		for _, id := range resume.Untimed {
+			untimed[id] = true
+		}
+	}
+	tailCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tail := f.client.Logs.Tail(tailCtx, f.Target, opts)
+	batchSize := f.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	flush := f.FlushInterval
+	if flush <= 0 {
+		flush = time.Second
+	}
+	var batch []*LogRecord
+	timer := time.NewTimer(flush)
+	defer timer.Stop()
+	for {
+		select {
+		case rec, ok := <-tail.C:
+			if !ok {
+				if _, err := f.deliver(ctx, batch, cp); err != nil {
+					return err
+				}
+				return tail.Err()
+			}
+			if resume != nil && resume.delivered(rec, skip, untimed) {
+				continue
+			}
+			batch = append(batch, rec)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-timer.C:
+		}
+		if len(batch) > 0 {
+			if cp, err = f.deliver(ctx, batch, cp); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(flush)
+	}
+}
+
+// deliver hands batch to every sink, retrying each failing sink until it
+// succeeds, then records and returns the new checkpoint.
+func (f *LogForwarder) deliver(ctx context.Context, batch []*LogRecord, cp *logForwardCheckpoint) (*logForwardCheckpoint, error) {
+	if len(batch) == 0 {
+		return cp, nil
+	}
+	retry := f.RetryInterval
+	if retry <= 0 {
+		retry = time.Second
+	}
+	for _, sink := range f.Sinks {
+		delay := retry
+		for {
+			err := sink.Write(batch)
+			if err == nil {
+				break
+			}
+			if f.OnError != nil {
+				f.OnError(sink, err)
+			}
+			select {
+			case <-ctx.Done():
+				return cp, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay = backoff(delay, time.Minute)
+		}
+	}
+	next := checkpointAfter(cp, batch)
+	return next, f.writeCheckpoint(next)
+}
+
+// delivered reports whether rec was delivered before the checkpoint was
+// written. Records without a timestamp are matched against the untimed
+// IDs instead of the checkpoint time.
+func (cp *logForwardCheckpoint) delivered(rec *LogRecord, ids, untimed map[string]bool) bool {
+	if rec.Time.IsZero() {
+		return untimed[logRecordKey(rec)]
+	}
+	return rec.Time.Before(cp.Time) || rec.Time.Equal(cp.Time) && ids[logRecordKey(rec)]
+}
+
+// checkpointAfter returns the checkpoint following delivery of batch.
+func checkpointAfter(cp *logForwardCheckpoint, batch []*LogRecord) *logForwardCheckpoint {
+	next := &logForwardCheckpoint{}
+	if cp != nil {
+		next.Time = cp.Time
+		next.IDs = append(next.IDs, cp.IDs...)
+		next.Untimed = append(next.Untimed, cp.Untimed...)
+	}
+	for _, rec := range batch {
+		switch {
+		case rec.Time.IsZero():
+			next.Untimed = append(next.Untimed, logRecordKey(rec))
+		case rec.Time.After(next.Time):
+			next.Time = rec.Time
+			next.IDs = []string{logRecordKey(rec)}
+		case rec.Time.Equal(next.Time):
+			next.IDs = append(next.IDs, logRecordKey(rec))
+		}
+	}
+	if n := len(next.Untimed); n > maxUntimedIDs {
+		next.Untimed = next.Untimed[n-maxUntimedIDs:]
+	}
+	return next
+}
+
+func (f *LogForwarder) readCheckpoint() (*logForwardCheckpoint, error) {
+	if f.Checkpoint == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(f.Checkpoint)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp logForwardCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("log forwarder checkpoint %s: %s", f.Checkpoint, err)
+	}
+	return &cp, nil
+}
+
+func (f *LogForwarder) writeCheckpoint(cp *logForwardCheckpoint) error {
+	if f.Checkpoint == "" {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.Checkpoint, b)
+}
@@ -0,0 +1,271 @@
+package gondor
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// LogSource is one input of a merged log view.
+type LogSource struct {
+	// Name tags every record read from this source.
+	Name   string
+	Target LogTarget
+}
+
+// ServiceLogSources returns a source for each service, named after it.
+func ServiceLogSources(services []*Service) []LogSource {
+	sources := make([]LogSource, len(services))
+	for i, s := range services {
+		sources[i] = LogSource{
+			Name:   stringValue(s.Name),
+			Target: LogTarget{Service: stringValue(s.URL)},
+		}
+	}
+	return sources
+}
+
+// SourcedLogRecord is a record from a merged view tagged with the name
+// of the source it came from.
+type SourcedLogRecord struct {
+	*LogRecord
+	Source string
+}
+
+// MergeOpts controls LogResource.Merge.
+type MergeOpts struct {
+	// LogRequestOpts selects the history range and filters. PageToken and
+	// Order are ignored.
+	LogRequestOpts
+
+	// Follow tails every source instead of reading history. The tail
+	// starts at After, or now if After is nil.
+	Follow bool
+	// Tail configures polling in follow mode.
+	Tail TailOpts
+	// ReorderWindow is how long a followed record is held back so that
+	// slightly late records from other sources can be emitted before it.
+	// Defaults to two seconds.
+	ReorderWindow time.Duration
+}
+
+// MergedLog is a merged view over several log sources. Records arrive on
+// C in timestamp order. C is closed when the view ends, after which Err
+// reports the first source error, if any.
+type MergedLog struct {
+	C <-chan *SourcedLogRecord
+
+	mu  sync.Mutex
+	err error
+}
+
+func (m *MergedLog) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *MergedLog) setErr(err error) {
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.mu.Unlock()
+}
+
+// Merge reads the logs of every source and merges them in timestamp
+// order. In history mode the view ends once every source is exhausted;
+// in follow mode it runs until ctx is done.
+func (r *LogResource) Merge(ctx context.Context, sources []LogSource, opts MergeOpts) *MergedLog {
+	ch := make(chan *SourcedLogRecord)
+	m := &MergedLog{C: ch}
+	go func() {
+		defer close(ch)
+		if opts.Follow {
+			r.mergeFollow(ctx, m, ch, sources, opts)
+		} else {
+			r.mergeHistory(ctx, m, ch, sources, opts)
+		}
+	}()
+	return m
+}
+
+// logCursor walks one source's history a page at a time.
+type logCursor struct {
+	r     *LogResource
+	src   LogSource
+	index int
+	opts  LogRequestOpts
+	page  []*LogRecord
+	done  bool
+}
+
+// fill fetches pages until the cursor holds a record or the source is
+// exhausted. Pages may be empty when the filter drops every record.
+func (c *logCursor) fill() error {
+	for len(c.page) == 0 && !c.done {
+		page, err := c.r.List(c.src.Target, c.opts)
+		if err != nil {
+			return err
+		}
+		c.page = page.Records
+		c.opts.PageToken = page.NextPageToken
+		c.done = page.NextPageToken == ""
+	}
+	return nil
+}
+
+func (c *logCursor) head() *LogRecord {
+	return c.page[0]
+}
+
+// logCursorHeap is a min-heap of cursors ordered by their next record;
+// ties go to the source listed first.
+type logCursorHeap []*logCursor
+
+func (h logCursorHeap) Len() int { return len(h) }
+func (h logCursorHeap) Less(i, j int) bool {
+	a, b := h[i].head(), h[j].head()
+	if a.Time.Equal(b.Time) {
+		return h[i].index < h[j].index
+	}
+	return a.Time.Before(b.Time)
+}
+func (h logCursorHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *logCursorHeap) Push(x interface{}) { *h = append(*h, x.(*logCursor)) }
+func (h *logCursorHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// mergeHistory is a k-way merge over the ascending pages of every
+// source, so only the current page of each source is held in memory.
+func (r *LogResource) mergeHistory(ctx context.Context, m *MergedLog, ch chan<- *SourcedLogRecord, sources []LogSource, opts MergeOpts) {
+	reqOpts := opts.LogRequestOpts
+	reqOpts.PageToken = ""
+	reqOpts.Order = "asc"
+	var cursors logCursorHeap
+	for i, src := range sources {
+		c := &logCursor{r: r, src: src, index: i, opts: reqOpts}
+		if err := c.fill(); err != nil {
+			m.setErr(err)
+			return
+		}
+		if len(c.page) > 0 {
+			cursors = append(cursors, c)
+		}
+	}
+	heap.Init(&cursors)
+	for cursors.Len() > 0 {
+		c := cursors[0]
+		rec := &SourcedLogRecord{LogRecord: c.head(), Source: c.src.Name}
+		select {
+		case ch <- rec:
+		case <-ctx.Done():
+			return
+		}
+		c.page = c.page[1:]
+		if err := c.fill(); err != nil {
+			m.setErr(err)
+			return
+		}
+		if len(c.page) == 0 {
+			heap.Pop(&cursors)
+		} else {
+			heap.Fix(&cursors, 0)
+		}
+	}
+}
+
+type pendingLogRecord struct {
+	rec     *SourcedLogRecord
+	arrived time.Time
+}
+
+// logReorderBuffer is a min-heap of records ordered by timestamp.
+type logReorderBuffer []pendingLogRecord
+
+func (b logReorderBuffer) Len() int            { return len(b) }
+func (b logReorderBuffer) Less(i, j int) bool  { return b[i].rec.Time.Before(b[j].rec.Time) }
+func (b logReorderBuffer) Swap(i, j int)       { b[i], b[j] = b[j], b[i] }
+func (b *logReorderBuffer) Push(x interface{}) { *b = append(*b, x.(pendingLogRecord)) }
+func (b *logReorderBuffer) Pop() interface{} {
+	old := *b
+	x := old[len(old)-1]
+	*b = old[:len(old)-1]
+	return x
+}
+
+func (r *LogResource) mergeFollow(ctx context.Context, m *MergedLog, ch chan<- *SourcedLogRecord, sources []LogSource, opts MergeOpts) {
+	window := opts.ReorderWindow
+	if window <= 0 {
+		window = 2 * time.Second
+	}
+	tailOpts := opts.Tail
+	tailOpts.Since = opts.After
+	tailOpts.LogFilter = opts.LogFilter
+	// tailCtx stops the tails when a source fails; records already
+	// buffered are still delivered until ctx itself is done.
+	tailCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	in := make(chan *SourcedLogRecord)
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src LogSource) {
+			defer wg.Done()
+			tail := r.Tail(tailCtx, src.Target, tailOpts)
+			for rec := range tail.C {
+				select {
+				case in <- &SourcedLogRecord{LogRecord: rec, Source: src.Name}:
+				case <-tailCtx.Done():
+				}
+			}
+			if err := tail.Err(); err != nil {
+				m.setErr(err)
+				cancel()
+			}
+		}(src)
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+	var buf logReorderBuffer
+	ticker := time.NewTicker(window / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec, ok := <-in:
+			if !ok {
+				// every tail has ended: flush what is still held back
+				for buf.Len() > 0 {
+					p := heap.Pop(&buf).(pendingLogRecord)
+					select {
+					case ch <- p.rec:
+					case <-ctx.Done():
+						return
+					}
+				}
+				return
+			}
+			heap.Push(&buf, pendingLogRecord{rec: rec, arrived: time.Now()})
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		// Emit records that have waited out the window. The oldest record
+		// gates everything behind it so ordering is preserved.
+		for buf.Len() > 0 && time.Since(buf[0].arrived) >= window {
+			p := heap.Pop(&buf).(pendingLogRecord)
+			select {
+			case ch <- p.rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package gondor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogSink delivers records as RFC 5424 messages over UDP, TCP or TLS.
+// Stream transports use octet-counting framing (RFC 6587).
+type SyslogSink struct {
+	// Network is "udp", "tcp" or "tls".
+	Network string
+	// Addr is the host:port of the syslog receiver.
+	Addr string
+	// TLSConfig is used when Network is "tls".
+	TLSConfig *tls.Config
+	// Facility is the syslog facility code; defaults to 1 (user).
+	Facility int
+	// Hostname and AppName fill the corresponding header fields. AppName
+	// defaults to the record tag.
+	Hostname string
+	AppName  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink returns a sink sending to addr over network.
+func NewSyslogSink(network, addr string, tlsConfig *tls.Config) (*SyslogSink, error) {
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("syslog: unsupported network %q; must be udp, tcp or tls", network)
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogSink{
+		Network:   network,
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+		Facility:  1,
+		Hostname:  hostname,
+	}, nil
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	switch s.Network {
+	case "tls":
+		return tls.Dial("tcp", s.Addr, s.TLSConfig)
+	default:
+		return net.Dial(s.Network, s.Addr)
+	}
+}
+
+func (s *SyslogSink) Write(records []*LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	for _, rec := range records {
+		msg := s.format(rec)
+		var err error
+		if s.Network == "udp" {
+			_, err = s.conn.Write(msg)
+		} else {
+			_, err = fmt.Fprintf(s.conn, "%d %s", len(msg), msg)
+		}
+		if err != nil {
+			// drop the connection so the retried batch reconnects
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// format renders rec as an RFC 5424 message.
+func (s *SyslogSink) format(rec *LogRecord) []byte {
+	ts := "-"
+	if !rec.Time.IsZero() {
+		ts = rec.Time.UTC().Format("2006-01-02T15:04:05.000000Z")
+	}
+	app := s.AppName
+	if app == "" {
+		app = stringValue(rec.Tag)
+	}
+	msgID := valueOrDefault(stringValue(rec.Stream), "-")
+	pri := s.Facility*8 + syslogSeverity(rec)
+	msg := strings.TrimRight(stringValue(rec.Message), "\n")
+	return []byte(fmt.Sprintf(
+		"<%d>1 %s %s %s - %s - %s",
+		pri,
+		ts,
+		syslogHeaderField(s.Hostname, 255),
+		syslogHeaderField(app, 48),
+		syslogHeaderField(msgID, 32),
+		msg,
+	))
+}
+
+func syslogSeverity(rec *LogRecord) int {
+	switch rec.Level() {
+	case LogLevelCritical:
+		return 2
+	case LogLevelError:
+		return 3
+	case LogLevelWarning:
+		return 4
+	case LogLevelInfo:
+		return 6
+	case LogLevelDebug:
+		return 7
+	}
+	if stringValue(rec.Stream) == "stderr" {
+		return 3
+	}
+	return 6
+}
+
+// syslogHeaderField makes s safe for a header field: printable ASCII
+// without spaces, truncated to max, or "-" if empty.
+func syslogHeaderField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	return valueOrDefault(s, "-")
+}
+
+// RotatingFileSink appends records to a local file, rotating it when it
+// grows past MaxBytes or has been open for longer than MaxAge. Rotated
+// files are renamed with a timestamp suffix and pruned to MaxBackups.
+type RotatingFileSink struct {
+	Path string
+	// Format is the record encoding; defaults to NDJSON.
+	Format LogExportFormat
+	// MaxBytes rotates the file once it reaches this size; zero disables.
+	MaxBytes int64
+	// MaxAge rotates the file once the sink has had it open this long;
+	// zero disables.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept; zero keeps all.
+	MaxBackups int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	encoder logEncoder
+}
+
+// NewRotatingFileSink returns a sink writing NDJSON to path.
+func NewRotatingFileSink(path string, maxBytes int64, maxAge time.Duration, maxBackups int) *RotatingFileSink {
+	return &RotatingFileSink{
+		Path:       path,
+		Format:     LogExportNDJSON,
+		MaxBytes:   maxBytes,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+}
+
+func (s *RotatingFileSink) open() error {
+	switch s.Format {
+	case "", LogExportNDJSON:
+		s.encoder = ndjsonLogEncoder{}
+	case LogExportText:
+		s.encoder = textLogEncoder{}
+	case LogExportCSV:
+		s.encoder = csvLogEncoder{}
+	default:
+		return fmt.Errorf("unknown log format %q", s.Format)
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size := fi.Size()
+	if size == 0 {
+		w := &countingWriter{w: f}
+		if err := s.encoder.header(w); err != nil {
+			f.Close()
+			return err
+		}
+		size = w.n
+	}
+	s.file = f
+	s.size = size
+	s.opened = time.Now()
+	return nil
+}
+
+func (s *RotatingFileSink) Write(records []*LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	buf := bufio.NewWriter(s.file)
+	for _, rec := range records {
+		if s.due() {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+			if err := s.rotate(); err != nil {
+				return err
+			}
+			buf.Reset(s.file)
+		}
+		w := &countingWriter{w: buf}
+		if err := s.encoder.encode(w, rec); err != nil {
+			return err
+		}
+		s.size += w.n
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *RotatingFileSink) due() bool {
+	if s.MaxBytes > 0 && s.size >= s.MaxBytes {
+		return true
+	}
+	return s.MaxAge > 0 && time.Since(s.opened) >= s.MaxAge
+}
+
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	rotated := fmt.Sprintf("%s.%s", s.Path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(s.Path, rotated); err != nil {
+		return err
+	}
+	if err := s.prune(); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *RotatingFileSink) prune() error {
+	if s.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(s.Path + ".[0-9]*")
+	if err != nil {
+		return err
+	}
+	// timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for len(backups) > s.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WebhookSink posts records as a JSON array to an HTTP endpoint, in
+// batches of up to BatchSize. Network errors and 5xx responses are
+// retried up to MaxRetries times per batch.
+type WebhookSink struct {
+	URL        string
+	Header     http.Header
+	BatchSize  int
+	MaxRetries int
+	// RetryInterval is the initial delay between retries; it doubles on
+	// every attempt. Defaults to 500ms.
+	RetryInterval time.Duration
+
+	httpClient *http.Client
+}
+
+// NewWebhookSink returns a sink posting to url with httpClient, or
+// http.DefaultClient if it is nil.
+func NewWebhookSink(url string, httpClient *http.Client) *WebhookSink {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &WebhookSink{
+		URL:           url,
+		Header:        http.Header{},
+		BatchSize:     100,
+		MaxRetries:    3,
+		RetryInterval: 500 * time.Millisecond,
+		httpClient:    httpClient,
+	}
+}
+
+func (s *WebhookSink) Write(records []*LogRecord) error {
+	size := s.BatchSize
+	if size <= 0 {
+		size = len(records)
+	}
+	for len(records) > 0 {
+		n := size
+		if n > len(records) {
+			n = len(records)
+		}
+		if err := s.post(records[:n]); err != nil {
+			return err
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+func (s *WebhookSink) post(records []*LogRecord) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	delay := s.RetryInterval
+	if delay <= 0 {
+		delay = 500 * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := s.send(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.MaxRetries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send posts body once and reports whether a failure is worth retrying.
+func (s *WebhookSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("webhook: non-2xx response; got %s", resp.Status)
+		return resp.StatusCode >= 500 || resp.StatusCode == 429, err
+	}
+	return false, nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
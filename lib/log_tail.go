@@ -0,0 +1,223 @@
+package gondor
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TailOpts controls LogResource.Tail.
+type TailOpts struct {
+	// Since is where tailing starts; nil starts from now.
+	Since *time.Time
+	// PageSize is passed through to each query.
+	PageSize int
+	// PollInterval is the delay between polls while records keep arriving.
+	// It defaults to one second.
+	PollInterval time.Duration
+	// MaxPollInterval caps the backoff applied while the log is idle or
+	// the API is failing. It defaults to 30 seconds.
+	MaxPollInterval time.Duration
+	// MaxErrors is the number of consecutive transient errors tolerated
+	// before giving up; zero retries forever. Only network errors and
+	// server errors are transient.
+	MaxErrors int
+	// Overlap is how far before the newest delivered record each poll
+	// reads again, so records that reach the API late are still picked
+	// up. Records later than that are dropped and counted; see
+	// LogTail.Dropped. It defaults to one second, the precision of the
+	// API's time filter.
+	Overlap time.Duration
+
+	LogFilter
+}
+
+// LogTail is a running follow of a log target. Records are delivered on
+// C in timestamp order. C is closed when the tail stops, after which Err
+// reports why.
+type LogTail struct {
+	C <-chan *LogRecord
+
+	mu      sync.Mutex
+	err     error
+	dropped int
+}
+
+// Err returns the error that stopped the tail, or nil if it stopped
+// because its context was done.
+func (t *LogTail) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Dropped returns how many records have been left out so far because
+// they were older than the overlap window when they arrived.
+func (t *LogTail) Dropped() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+// Tail follows the log of target until ctx is done. Each poll walks every
+// page of new records, drops records already delivered and backs off
+// while the log is idle. Transient errors are retried from the last
+// fetched timestamp.
+func (r *LogResource) Tail(ctx context.Context, target LogTarget, opts TailOpts) *LogTail {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	opts.Overlap = durationOrDefault(opts.Overlap, time.Second)
+	if opts.MaxPollInterval < opts.PollInterval {
+		opts.MaxPollInterval = 30 * time.Second
+		if opts.MaxPollInterval < opts.PollInterval {
+			opts.MaxPollInterval = opts.PollInterval
+		}
+	}
+	ch := make(chan *LogRecord)
+	t := &LogTail{C: ch}
+	go func() {
+		err := r.follow(ctx, t, target, opts, ch)
+		t.mu.Lock()
+		t.err = err
+		t.mu.Unlock()
+		close(ch)
+	}()
+	return t
+}
+
+func (r *LogResource) follow(ctx context.Context, t *LogTail, target LogTarget, opts TailOpts, ch chan<- *LogRecord) error {
+	var cursor time.Time
+	if opts.Since != nil {
+		cursor = *opts.Since
+	} else {
+		cursor = time.Now()
+	}
+	// Every poll overlaps the previous one, both for the API's second
+	// precision and for late records, and records are de-duplicated
+	// against the IDs seen within the overlap.
+	seen := make(map[string]time.Time)
+	delay := opts.PollInterval
+	errs := 0
+	for {
+		since := cursor.Add(-opts.Overlap)
+		records, err := r.fetchSince(target, since, opts)
+		if err != nil {
+			if !isTransient(err) {
+				return err
+			}
+			errs++
+			if opts.MaxErrors > 0 && errs >= opts.MaxErrors {
+				return err
+			}
+			delay = backoff(delay, opts.MaxPollInterval)
+		} else {
+			errs = 0
+			fresh := 0
+			for _, rec := range records {
+				ts, key := rec.Time, logRecordKey(rec)
+				if ts.IsZero() {
+					ts = cursor
+				}
+				if ts.Before(since) {
+					t.mu.Lock()
+					t.dropped++
+					t.mu.Unlock()
+					continue
+				}
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				// the cursor moves past records the filter drops too, so
+				// a rarely matching filter does not rescan the log
+				if opts.LogFilter.Match(rec) {
+					select {
+					case ch <- rec:
+					case <-ctx.Done():
+						return nil
+					}
+				}
+				seen[key] = ts
+				if ts.After(cursor) {
+					cursor = ts
+				}
+				fresh++
+			}
+			for key, ts := range seen {
+				if ts.Before(cursor.Add(-opts.Overlap - time.Second)) {
+					delete(seen, key)
+				}
+			}
+			if fresh > 0 {
+				delay = opts.PollInterval
+			} else {
+				delay = backoff(delay, opts.MaxPollInterval)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// fetchSince returns every record after since, following page tokens,
+// sorted by timestamp. Only the server-side parts of the filter are
+// applied; the caller matches the rest while delivering.
+func (r *LogResource) fetchSince(target LogTarget, since time.Time, tailOpts TailOpts) ([]*LogRecord, error) {
+	filter := tailOpts.LogFilter
+	filter.Pattern, filter.MinLevel = nil, LogLevelUnknown
+	opts := LogRequestOpts{
+		PageSize:  tailOpts.PageSize,
+		After:     &since,
+		Order:     "asc",
+		LogFilter: filter,
+	}
+	records, _, err := r.Collect(target, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+func backoff(delay, max time.Duration) time.Duration {
+	delay *= 2
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// isTransient reports whether err is worth retrying: a network failure
+// or a server error. Anything else, such as an authentication or TLS
+// certificate failure, would fail the same way again.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case serverError:
+		return true
+	case *url.Error:
+		return isTransient(e.Err)
+	case *net.OpError:
+		// TLS alerts are reported as local or remote errors
+		return e.Op != "local error" && e.Op != "remote error"
+	case net.Error:
+		return e.Timeout()
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// logRecordKey identifies a record for de-duplication.
+func logRecordKey(rec *LogRecord) string {
+	if rec.ID != nil {
+		return *rec.ID
+	}
+	return stringValue(rec.Timestamp) + "\x00" + stringValue(rec.Stream) + "\x00" + stringValue(rec.Message)
+}
@@ -2,6 +2,7 @@ package gondor
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -30,6 +31,74 @@ func drainBody(b io.ReadCloser) (r1, r2 io.ReadCloser, err error) {
 	return ioutil.NopCloser(&buf), ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
 }
 
+// redactedFields are JSON fields whose values never appear in HTTP logs.
+// String "value" fields, which hold environment variable values, are
+// masked too.
+var redactedFields = map[string]bool{
+	"private_registry_key": true,
+	"password":             true,
+	"auth":                 true,
+}
+
+// redactBody returns a JSON body with credentials masked. Bodies that
+// are not JSON or hold no credentials are returned unchanged.
+func redactBody(b []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil || !redactValue(v) {
+		return b
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+func redactValue(v interface{}) bool {
+	redacted := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, x := range v {
+			// environment variable values are strings; metric and alert
+			// values are numbers and stay readable
+			_, isString := x.(string)
+			if redactedFields[k] && x != nil || k == "value" && isString {
+				v[k] = "[REDACTED]"
+				redacted = true
+			} else if redactValue(x) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, x := range v {
+			if redactValue(x) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+// redactHeader returns a copy of h with credentials masked, keeping the
+// Authorization scheme so the kind of credentials is still visible.
+func redactHeader(h http.Header) http.Header {
+	values := h["Authorization"]
+	if len(values) == 0 {
+		return h
+	}
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		out[k] = v
+	}
+	redacted := make([]string, len(values))
+	for i, v := range values {
+		scheme := strings.SplitN(v, " ", 2)[0]
+		redacted[i] = strings.TrimSpace(scheme + " [REDACTED]")
+	}
+	out["Authorization"] = redacted
+	return out
+}
+
 func valueOrDefault(value, def string) string {
 	if value != "" {
 		return value
@@ -73,7 +142,7 @@ func (c *Client) logRequest(req *http.Request) error {
 		if req.Close {
 			fmt.Fprintf(os.Stderr, "Connection: close\r\n")
 		}
-		err = req.Header.WriteSubset(os.Stderr, reqWriteExcludeHeaderDump)
+		err = redactHeader(req.Header).WriteSubset(os.Stderr, reqWriteExcludeHeaderDump)
 		if err != nil {
 			return err
 		}
@@ -84,7 +153,10 @@ func (c *Client) logRequest(req *http.Request) error {
 			if chunked {
 				dest = httputil.NewChunkedWriter(dest)
 			}
-			_, err = io.Copy(dest, req.Body)
+			var b []byte
+			if b, err = ioutil.ReadAll(req.Body); err == nil {
+				_, err = dest.Write(redactBody(b))
+			}
 			if chunked {
 				dest.(io.Closer).Close()
 				io.WriteString(os.Stderr, "\r\n")
@@ -124,6 +196,13 @@ func (c *Client) logResponse(resp *http.Response) error {
 			if err != nil {
 				return err
 			}
+			b, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			b = redactBody(b)
+			resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+			resp.ContentLength = int64(len(b))
 		}
 		fmt.Println("----------- response start -----------")
 		err = resp.Write(os.Stderr)
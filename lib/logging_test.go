@@ -0,0 +1,82 @@
+package gondor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"not json", "password=hunter2", "password=hunter2"},
+		{"no credentials", `{"name":"web","replicas":2}`, `{"name":"web","replicas":2}`},
+		{"password", `{"username":"bob","password":"hunter2"}`, `{"password":"[REDACTED]","username":"bob"}`},
+		{"registry key", `{"name":"site","private_registry_key":"c2VjcmV0"}`, `{"name":"site","private_registry_key":"[REDACTED]"}`},
+		{"docker auth", `{"auths":{"r.example.com":{"auth":"Ym9iOmh1bnRlcjI="}}}`, `{"auths":{"r.example.com":{"auth":"[REDACTED]"}}}`},
+		{"env var value", `{"key":"SECRET_KEY","value":"s3cr3t","instance":"/i/1/"}`, `{"instance":"/i/1/","key":"SECRET_KEY","value":"[REDACTED]"}`},
+		{"env var list", `[{"key":"A","value":"1"},{"key":"B","value":""}]`, `[{"key":"A","value":"[REDACTED]"},{"key":"B","value":"[REDACTED]"}]`},
+		{"numeric value", `{"metric":"cpu","value":0.5}`, `{"metric":"cpu","value":0.5}`},
+		{"null kept", `{"password":null,"name":"x"}`, `{"password":null,"name":"x"}`},
+		{"nested", `{"results":[{"site":{"private_registry_key":"x"}}]}`, `{"results":[{"site":{"private_registry_key":"[REDACTED]"}}]}`},
+	}
+	for _, tt := range tests {
+		if got := string(redactBody([]byte(tt.body))); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer abc123")
+	h.Set("Accept", "application/json")
+	got := redactHeader(h)
+	want := http.Header{
+		"Authorization": {"Bearer [REDACTED]"},
+		"Accept":        {"application/json"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if h.Get("Authorization") != "Bearer abc123" {
+		t.Errorf("the request header was modified: %v", h)
+	}
+}
+
+func TestLogRequestRedactsCredentials(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	c := NewClient(&Config{}, &http.Client{})
+	c.EnableHTTPLogging(true)
+	req, _ := http.NewRequest("POST", "https://api.example.com/v2/envvars/", strings.NewReader(`{"key":"TOKEN","value":"s3cr3t"}`))
+	req.Header.Set("Authorization", "Bearer abc123")
+	err = c.logRequest(req)
+	w.Close()
+	os.Stderr = stderr
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	for _, secret := range []string{"abc123", "s3cr3t"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("request log contains %q:\n%s", secret, out)
+		}
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != `{"key":"TOKEN","value":"s3cr3t"}` {
+		t.Errorf("logging changed the request body to %s", body)
+	}
+}
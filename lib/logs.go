@@ -1,6 +1,9 @@
 package gondor
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
 	"strconv"
 	"time"
@@ -16,6 +19,39 @@ type LogRecord struct {
 	Message   *string `json:"log"`
 	Stream    *string `json:"stream"`
 	Tag       *string `json:"tag"`
+
+	// Time is Timestamp parsed; it is zero if Timestamp is missing or
+	// not in a recognized format.
+	Time time.Time `json:"-"`
+}
+
+func (rec *LogRecord) UnmarshalJSON(data []byte) error {
+	type record LogRecord
+	if err := json.Unmarshal(data, (*record)(rec)); err != nil {
+		return err
+	}
+	rec.Time = time.Time{}
+	if rec.Timestamp != nil {
+		rec.Time, _ = parseLogTimestamp(*rec.Timestamp)
+	}
+	return nil
+}
+
+var logTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02T15:04:05.999999999",
+}
+
+func parseLogTimestamp(s string) (time.Time, error) {
+	var err error
+	for _, layout := range logTimestampLayouts {
+		var ts time.Time
+		if ts, err = time.Parse(layout, s); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, err
 }
 
 // LogRequestOpts ...
@@ -24,7 +60,10 @@ type LogRequestOpts struct {
 	After     *time.Time
 	Before    *time.Time
 	PageToken string
-	Order     string
+	// Order is "asc" or "desc"; empty leaves the API default.
+	Order string
+
+	LogFilter
 }
 
 // LogRecordPage represents a single log record page
@@ -46,6 +85,16 @@ func (r *LogResource) query(u *url.URL, q url.Values, opts LogRequestOpts) (*Log
 	if opts.PageToken != "" {
 		q.Add("page_token", opts.PageToken)
 	}
+	switch opts.Order {
+	case "":
+	case "asc", "desc":
+		q.Add("order", opts.Order)
+	default:
+		return nil, fmt.Errorf("log order must be asc or desc, not %q", opts.Order)
+	}
+	if err := opts.LogFilter.addTo(q); err != nil {
+		return nil, err
+	}
 	u.RawQuery = q.Encode()
 	var res []*LogRecord
 	resp, err := r.client.Get(u, &res)
@@ -53,24 +102,55 @@ func (r *LogResource) query(u *url.URL, q url.Values, opts LogRequestOpts) (*Log
 		return nil, err
 	}
 	page := &LogRecordPage{
-		Records:       res,
+		Records:       opts.LogFilter.apply(res),
 		NextPageToken: resp.Header.Get("X-Log-Page-Token"),
 	}
 	return page, nil
 }
 
+// LogTarget selects whose records a log query returns. Exactly one
+// field should be set.
+type LogTarget struct {
+	Instance string
+	Service  string
+	Task     string
+}
+
+func (t LogTarget) values() (url.Values, error) {
+	q := url.Values{}
+	switch {
+	case t.Service != "":
+		q.Add("service", t.Service)
+	case t.Instance != "":
+		q.Add("instance", t.Instance)
+	case t.Task != "":
+		q.Add("task", t.Task)
+	default:
+		return nil, errors.New("log target must name an instance, service or task")
+	}
+	return q, nil
+}
+
+// List returns a single page of records for target.
+func (r *LogResource) List(target LogTarget, opts LogRequestOpts) (*LogRecordPage, error) {
+	q, err := target.values()
+	if err != nil {
+		return nil, err
+	}
+	return r.query(r.client.buildBaseURL("logs/"), q, opts)
+}
+
 // ListByInstance ...
 func (r *LogResource) ListByInstance(instanceURL string, opts LogRequestOpts) (*LogRecordPage, error) {
-	url := r.client.buildBaseURL("logs/")
-	q := url.Query()
-	q.Add("instance", instanceURL)
-	return r.query(url, q, opts)
+	return r.List(LogTarget{Instance: instanceURL}, opts)
 }
 
 // ListByService ...
 func (r *LogResource) ListByService(serviceURL string, opts LogRequestOpts) (*LogRecordPage, error) {
-	url := r.client.buildBaseURL("logs/")
-	q := url.Query()
-	q.Add("service", serviceURL)
-	return r.query(url, q, opts)
+	return r.List(LogTarget{Service: serviceURL}, opts)
+}
+
+// ListByTask ...
+func (r *LogResource) ListByTask(taskURL string, opts LogRequestOpts) (*LogRecordPage, error) {
+	return r.List(LogTarget{Task: taskURL}, opts)
 }
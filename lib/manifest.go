@@ -0,0 +1,192 @@
+package gondor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// Manifest declares the desired state of a site. Manifests are YAML or
+// JSON documents whose keys are the json names of the fields.
+type Manifest struct {
+	Site string `json:"site"`
+	// ResourceGroup is the name of the resource group owning the site;
+	// empty uses the authenticated user's resource group.
+	ResourceGroup string             `json:"resource_group,omitempty"`
+	Env           map[string]string  `json:"env,omitempty"`
+	Instances     []ManifestInstance `json:"instances,omitempty"`
+}
+
+type ManifestInstance struct {
+	Label          string                  `json:"label"`
+	Kind           string                  `json:"kind,omitempty"`
+	Env            map[string]string       `json:"env,omitempty"`
+	Services       []ManifestService       `json:"services,omitempty"`
+	Hosts          []ManifestHost          `json:"hosts,omitempty"`
+	ScheduledTasks []ManifestScheduledTask `json:"scheduled_tasks,omitempty"`
+}
+
+// ManifestService declares a service. A nil Replicas leaves the replica
+// count of an existing service alone; Version and OpenPorts are set on
+// create and cannot be changed afterwards.
+type ManifestService struct {
+	Name      string            `json:"name"`
+	Kind      string            `json:"kind"`
+	Size      string            `json:"size,omitempty"`
+	Replicas  *int              `json:"replicas,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	OpenPorts string            `json:"open_ports,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type ManifestHost struct {
+	Host string `json:"host"`
+	// KeyPair is the name of a keypair in the site's resource group.
+	KeyPair string `json:"keypair,omitempty"`
+}
+
+type ManifestScheduledTask struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone,omitempty"`
+	Command  string `json:"command"`
+}
+
+// LoadManifest decodes and validates a YAML or JSON manifest; documents
+// starting with '{' are read as JSON. Unknown fields are rejected so
+// typos do not silently drop configuration.
+func LoadManifest(r io.Reader) (*Manifest, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&m)
+	} else {
+		err = unmarshalYAML(b, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %s", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// WriteManifest encodes m as indented JSON.
+func WriteManifest(w io.Writer, m *Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// Validate checks the manifest for problems the API would reject and for
+// duplicate names. Problems are reported as an APIError keyed by the
+// path of the offending field.
+func (m *Manifest) Validate() error {
+	errList := ErrorList{}
+	add := func(path, msg string) {
+		errList[path] = append(errList[path], msg)
+	}
+	if m.Site == "" {
+		add("site", "is required")
+	}
+	labels := make(map[string]bool)
+	for i, inst := range m.Instances {
+		path := fmt.Sprintf("instances[%d]", i)
+		if inst.Label == "" {
+			add(path+".label", "is required")
+		} else if labels[inst.Label] {
+			add(path+".label", fmt.Sprintf("duplicate instance %q", inst.Label))
+		}
+		labels[inst.Label] = true
+		names := make(map[string]bool)
+		for j, svc := range inst.Services {
+			spath := fmt.Sprintf("%s.services[%d]", path, j)
+			if svc.Name == "" {
+				add(spath+".name", "is required")
+			} else if names[svc.Name] {
+				add(spath+".name", fmt.Sprintf("duplicate service %q", svc.Name))
+			}
+			names[svc.Name] = true
+			if err := svc.service().validate(true); err != nil {
+				fields, _ := ErrorFields(err)
+				for _, field := range sortedErrorKeys(fields) {
+					for _, msg := range fields[field] {
+						add(spath+"."+field, msg)
+					}
+				}
+			}
+		}
+		hosts := make(map[string]bool)
+		for j, host := range inst.Hosts {
+			hpath := fmt.Sprintf("%s.hosts[%d].host", path, j)
+			if host.Host == "" {
+				add(hpath, "is required")
+			} else if hosts[host.Host] {
+				add(hpath, fmt.Sprintf("duplicate host %q", host.Host))
+			}
+			hosts[host.Host] = true
+		}
+		tasks := make(map[string]bool)
+		for j, task := range inst.ScheduledTasks {
+			tpath := fmt.Sprintf("%s.scheduled_tasks[%d]", path, j)
+			if task.Name == "" {
+				add(tpath+".name", "is required")
+			} else if tasks[task.Name] {
+				add(tpath+".name", fmt.Sprintf("duplicate scheduled task %q", task.Name))
+			}
+			tasks[task.Name] = true
+			if task.Schedule == "" {
+				add(tpath+".schedule", "is required")
+			}
+			if task.Command == "" {
+				add(tpath+".command", "is required")
+			}
+		}
+	}
+	if len(errList) > 0 {
+		return apiError{errList: errList}
+	}
+	return nil
+}
+
+// service returns the Service the API would be sent to create ms.
+func (ms ManifestService) service() *Service {
+	s := &Service{
+		Name:      &ms.Name,
+		Kind:      &ms.Kind,
+		OpenPorts: ms.OpenPorts,
+	}
+	if ms.Size != "" {
+		s.Size = &ms.Size
+	}
+	if ms.Replicas != nil {
+		n := *ms.Replicas
+		s.Replicas = &n
+	}
+	if ms.Version != "" {
+		s.Version = &ms.Version
+	}
+	return s
+}
+
+func sortedErrorKeys(errList ErrorList) []string {
+	keys := make([]string, 0, len(errList))
+	for k := range errList {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,174 @@
+package gondor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ManifestSecretPlaceholder stands in for environment variable values left
+// out of an exported manifest. Applying a manifest keeps the current value
+// of any variable set to it.
+const ManifestSecretPlaceholder = "<secret>"
+
+// secretKeyMarkers are substrings of environment variable names whose
+// values are treated as secret by default.
+var secretKeyMarkers = []string{
+	"SECRET",
+	"PASSWORD",
+	"PASSWD",
+	"TOKEN",
+	"KEY",
+	"CREDENTIAL",
+	"PRIVATE",
+	"DSN",
+	"DATABASE_URL",
+}
+
+// IsSecretKey reports whether an environment variable name looks like it
+// holds a secret, e.g. SECRET_KEY, DB_PASSWORD or GITHUB_TOKEN.
+func IsSecretKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, marker := range secretKeyMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportOpts controls ExportManifest.
+type ExportOpts struct {
+	// Secrets includes secret values instead of placeholders.
+	Secrets bool
+	// IsSecret decides which variables are secret; defaults to IsSecretKey.
+	IsSecret func(key string) bool
+}
+
+// ExportManifest describes the current state of site as a manifest.
+// Collections are sorted so exports of the same state are identical, and
+// applying the result to the site yields an empty plan.
+func (c *Client) ExportManifest(site *Site, opts ExportOpts) (*Manifest, error) {
+	isSecret := opts.IsSecret
+	if isSecret == nil {
+		isSecret = IsSecretKey
+	}
+	env := func(envVars []*EnvironmentVariable) map[string]string {
+		if len(envVars) == 0 {
+			return nil
+		}
+		m := make(map[string]string, len(envVars))
+		for _, ev := range envVars {
+			key := stringValue(ev.Key)
+			if !opts.Secrets && isSecret(key) {
+				m[key] = ManifestSecretPlaceholder
+			} else {
+				m[key] = stringValue(ev.Value)
+			}
+		}
+		return m
+	}
+
+	m := &Manifest{Site: stringValue(site.Name)}
+	if site.ResourceGroup != nil {
+		rg, err := c.ResourceGroups.GetFromURL(*site.ResourceGroup)
+		if err != nil {
+			return nil, err
+		}
+		m.ResourceGroup = stringValue(rg.Name)
+	}
+	keyPairs, err := c.KeyPairs.List(site.ResourceGroup)
+	if err != nil {
+		return nil, err
+	}
+	keyPairNames := make(map[string]string)
+	for _, kp := range keyPairs {
+		keyPairNames[stringValue(kp.URL)] = stringValue(kp.Name)
+	}
+	siteEnv, err := c.EnvVars.ListBySite(*site.URL)
+	if err != nil {
+		return nil, err
+	}
+	m.Env = env(siteEnv)
+
+	instances, err := c.Instances.List(site.URL)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		mi := ManifestInstance{
+			Label: stringValue(instance.Label),
+			Kind:  stringValue(instance.Kind),
+		}
+		instanceEnv, err := c.EnvVars.ListByInstance(*instance.URL)
+		if err != nil {
+			return nil, err
+		}
+		mi.Env = env(instanceEnv)
+
+		services, err := c.Services.List(instance.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			ms := ManifestService{
+				Name: stringValue(service.Name),
+				Kind: stringValue(service.Kind),
+				Size: stringValue(service.Size),
+			}
+			if service.Replicas != nil {
+				n := *service.Replicas
+				ms.Replicas = &n
+			}
+			serviceEnv, err := c.EnvVars.ListByService(*service.URL)
+			if err != nil {
+				return nil, err
+			}
+			ms.Env = env(serviceEnv)
+			mi.Services = append(mi.Services, ms)
+		}
+		sort.Slice(mi.Services, func(i, j int) bool {
+			return mi.Services[i].Name < mi.Services[j].Name
+		})
+
+		hosts, err := c.HostNames.List(instance.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			mh := ManifestHost{Host: stringValue(host.Host)}
+			if host.KeyPair != nil {
+				name, ok := keyPairNames[*host.KeyPair]
+				if !ok {
+					return nil, fmt.Errorf("host %q: keypair %s is not in the site's resource group", mh.Host, *host.KeyPair)
+				}
+				mh.KeyPair = name
+			}
+			mi.Hosts = append(mi.Hosts, mh)
+		}
+		sort.Slice(mi.Hosts, func(i, j int) bool {
+			return mi.Hosts[i].Host < mi.Hosts[j].Host
+		})
+
+		tasks, err := c.ScheduledTasks.List(instance.URL)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			mi.ScheduledTasks = append(mi.ScheduledTasks, ManifestScheduledTask{
+				Name:     stringValue(task.Name),
+				Schedule: stringValue(task.Schedule),
+				Timezone: stringValue(task.Timezone),
+				Command:  stringValue(task.Command),
+			})
+		}
+		sort.Slice(mi.ScheduledTasks, func(i, j int) bool {
+			return mi.ScheduledTasks[i].Name < mi.ScheduledTasks[j].Name
+		})
+		m.Instances = append(m.Instances, mi)
+	}
+	sort.Slice(m.Instances, func(i, j int) bool {
+		return m.Instances[i].Label < m.Instances[j].Label
+	})
+	return m, nil
+}
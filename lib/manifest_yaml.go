@@ -0,0 +1,569 @@
+package gondor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The manifest YAML reader supports the block subset of YAML manifests
+// are written in: nested mappings and sequences, plain, single and
+// double quoted scalars, literal (|) and folded (>) block scalars,
+// comments and empty flow collections ([] and {}). Anchors, aliases,
+// tags, other flow collections and multiple documents are rejected.
+
+type yamlKind int
+
+const (
+	yamlScalar yamlKind = iota
+	yamlMapping
+	yamlSequence
+)
+
+type yamlNode struct {
+	kind yamlKind
+	line int
+
+	// scalars
+	value  string
+	null   bool
+	quoted bool
+
+	// mappings, in document order
+	keys     []string
+	keyLines []int
+	values   []*yamlNode
+
+	// sequences
+	items []*yamlNode
+}
+
+type yamlError struct {
+	line int
+	msg  string
+}
+
+func (e *yamlError) Error() string {
+	return fmt.Sprintf("yaml: line %d: %s", e.line, e.msg)
+}
+
+func yamlErrorf(line int, format string, a ...interface{}) error {
+	return &yamlError{line: line, msg: fmt.Sprintf(format, a...)}
+}
+
+// yamlLine is a line holding content, with its comment removed.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []string
+	// pos is the index of the next unread line
+	pos int
+}
+
+// unmarshalYAML decodes the YAML document in src into the value pointed
+// to by v, matching mapping keys to json struct tags. Keys without a
+// matching field are rejected.
+func unmarshalYAML(src []byte, v interface{}) error {
+	root, err := parseYAML(src)
+	if err != nil {
+		return err
+	}
+	return decodeYAML(root, reflect.ValueOf(v).Elem())
+}
+
+func parseYAML(src []byte) (*yamlNode, error) {
+	lines := strings.Split(string(src), "\n")
+	// a final newline ends the last line rather than starting another,
+	// which a kept (|+) block scalar would otherwise count
+	if n := len(lines); n > 1 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	p := &yamlParser{lines: lines}
+	l, ok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if ok && isYAMLDocumentMarker(l) && l.text == "---" {
+		p.pos++
+	}
+	root, err := p.parseChild(-1, 1)
+	if err != nil {
+		return nil, err
+	}
+	l, ok, err = p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if ok && isYAMLDocumentMarker(l) && l.text == "..." {
+		p.pos++
+		l, ok, err = p.peek()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ok {
+		if l.text == "---" {
+			return nil, yamlErrorf(l.num, "multiple documents are not supported")
+		}
+		return nil, yamlErrorf(l.num, "unexpected %q", l.text)
+	}
+	return root, nil
+}
+
+// peek returns the next line holding content without consuming it.
+func (p *yamlParser) peek() (yamlLine, bool, error) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		indent := 0
+		for indent < len(raw) && raw[indent] == ' ' {
+			indent++
+		}
+		text := stripYAMLComment(raw[indent:])
+		if text == "" {
+			continue
+		}
+		if text[0] == '\t' {
+			return yamlLine{}, false, yamlErrorf(p.pos+1, "tabs cannot be used for indentation")
+		}
+		return yamlLine{num: p.pos + 1, indent: indent, text: text}, true, nil
+	}
+	return yamlLine{}, false, nil
+}
+
+// stripYAMLComment removes a trailing comment and whitespace. A '#'
+// starts a comment only at the start of the text or after whitespace,
+// and never inside a quoted scalar.
+func stripYAMLComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '#':
+			if i == 0 || text[i-1] == ' ' || text[i-1] == '\t' {
+				return strings.TrimRight(text[:i], " \t")
+			}
+		case '"', '\'':
+			if i > 0 && text[i-1] != ' ' {
+				continue
+			}
+			// skip to the closing quote
+			for i++; i < len(text); i++ {
+				if c == '"' && text[i] == '\\' {
+					i++
+				} else if text[i] == c {
+					if c == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		}
+	}
+	return strings.TrimRight(text, " \t")
+}
+
+// isYAMLDocumentMarker reports whether l starts or ends a document,
+// which closes every open block.
+func isYAMLDocumentMarker(l yamlLine) bool {
+	return l.indent == 0 && (l.text == "---" || l.text == "...")
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseChild parses the block node on the following lines if they are
+// indented past parent, and returns a null node otherwise.
+func (p *yamlParser) parseChild(parent, line int) (*yamlNode, error) {
+	l, ok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if !ok || l.indent <= parent || isYAMLDocumentMarker(l) {
+		return &yamlNode{kind: yamlScalar, null: true, line: line}, nil
+	}
+	return p.parseBlock(l)
+}
+
+func (p *yamlParser) parseBlock(first yamlLine) (*yamlNode, error) {
+	if isYAMLSequenceItem(first.text) {
+		return p.parseSequence(first)
+	}
+	if _, _, ok, err := splitYAMLKey(first.text, first.num); err != nil {
+		return nil, err
+	} else if ok {
+		return p.parseMapping(first)
+	}
+	p.pos++
+	return parseYAMLScalar(first.text, first.num)
+}
+
+func (p *yamlParser) parseSequence(first yamlLine) (*yamlNode, error) {
+	n := &yamlNode{kind: yamlSequence, line: first.num}
+	for {
+		l, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		// anything else at this indentation belongs to the parent
+		if !ok || l.indent < first.indent || l.indent == first.indent && !isYAMLSequenceItem(l.text) || isYAMLDocumentMarker(l) {
+			return n, nil
+		}
+		if l.indent > first.indent {
+			return nil, yamlErrorf(l.num, "unexpected indentation")
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var item *yamlNode
+		if rest == "" {
+			p.pos++
+			item, err = p.parseChild(first.indent, l.num)
+		} else {
+			// read the item as a block starting where its content does,
+			// so "- key: value" continues on the lines below
+			indent := first.indent + len(l.text) - len(rest)
+			p.lines[p.pos] = strings.Repeat(" ", indent) + rest
+			item, err = p.parseBlock(yamlLine{num: l.num, indent: indent, text: rest})
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+	}
+}
+
+func (p *yamlParser) parseMapping(first yamlLine) (*yamlNode, error) {
+	n := &yamlNode{kind: yamlMapping, line: first.num}
+	seen := make(map[string]bool)
+	for {
+		l, ok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || l.indent < first.indent || isYAMLDocumentMarker(l) {
+			return n, nil
+		}
+		if l.indent > first.indent {
+			return nil, yamlErrorf(l.num, "unexpected indentation")
+		}
+		key, rest, ok, err := splitYAMLKey(l.text, l.num)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, yamlErrorf(l.num, "expected a mapping key")
+		}
+		if seen[key] {
+			return nil, yamlErrorf(l.num, "duplicate key %q", key)
+		}
+		seen[key] = true
+		p.pos++
+		var value *yamlNode
+		switch {
+		case rest == "":
+			// a sequence may sit at the indentation of its key
+			next, ok, err := p.peek()
+			if err != nil {
+				return nil, err
+			}
+			if ok && next.indent == first.indent && isYAMLSequenceItem(next.text) {
+				value, err = p.parseSequence(next)
+			} else {
+				value, err = p.parseChild(first.indent, l.num)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.parseBlockScalar(rest, first.indent, l.num)
+		default:
+			value, err = parseYAMLScalar(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.keyLines = append(n.keyLines, l.num)
+		n.values = append(n.values, value)
+	}
+}
+
+// splitYAMLKey splits "key: value" into the key and the rest of the line.
+// ok is false if text is not a mapping entry.
+func splitYAMLKey(text string, line int) (key, rest string, ok bool, err error) {
+	if isYAMLSequenceItem(text) {
+		return "", "", false, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		key, after, err := parseYAMLQuoted(text, line)
+		if err != nil {
+			return "", "", false, err
+		}
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		return key, strings.TrimLeft(after[1:], " "), true, nil
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false, nil
+		}
+		i = len(text) - 1
+	}
+	return strings.TrimRight(text[:i], " "), strings.TrimLeft(text[i+1:], " "), true, nil
+}
+
+func parseYAMLScalar(text string, line int) (*yamlNode, error) {
+	n := &yamlNode{kind: yamlScalar, line: line}
+	switch text[0] {
+	case '"', '\'':
+		value, after, err := parseYAMLQuoted(text, line)
+		if err != nil {
+			return nil, err
+		}
+		if after != "" {
+			return nil, yamlErrorf(line, "unexpected %q after quoted value", after)
+		}
+		n.value, n.quoted = value, true
+	case '[', '{':
+		switch text {
+		case "[]":
+			return &yamlNode{kind: yamlSequence, line: line}, nil
+		case "{}":
+			return &yamlNode{kind: yamlMapping, line: line}, nil
+		}
+		return nil, yamlErrorf(line, "flow collections are not supported")
+	case '&', '*', '!':
+		return nil, yamlErrorf(line, "anchors, aliases and tags are not supported")
+	default:
+		switch text {
+		case "~", "null", "Null", "NULL":
+			n.null = true
+		default:
+			n.value = text
+		}
+	}
+	return n, nil
+}
+
+// parseYAMLQuoted reads the quoted scalar text starts with and returns
+// its value and the text after the closing quote.
+func parseYAMLQuoted(text string, line int) (string, string, error) {
+	q := text[0]
+	var buf bytes.Buffer
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			buf.WriteByte('\'')
+			i++
+		case c == q:
+			return buf.String(), strings.TrimLeft(text[i+1:], " "), nil
+		case c == '\\' && q == '"':
+			if i+1 >= len(text) {
+				return "", "", yamlErrorf(line, "unterminated double quoted value")
+			}
+			i++
+			n, err := writeYAMLEscape(&buf, text[i:])
+			if err != nil {
+				return "", "", yamlErrorf(line, "%s", err)
+			}
+			i += n
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if q == '"' {
+		return "", "", yamlErrorf(line, "unterminated double quoted value")
+	}
+	return "", "", yamlErrorf(line, "unterminated single quoted value")
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", 'n': "\n", 'v': "\v",
+	'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\",
+}
+
+// writeYAMLEscape writes the character escaped at the start of s and
+// returns the number of bytes of s the escape used beyond the first.
+func writeYAMLEscape(buf *bytes.Buffer, s string) (int, error) {
+	if r, ok := yamlEscapes[s[0]]; ok {
+		buf.WriteString(r)
+		return 0, nil
+	}
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[s[0]]
+	if digits == 0 {
+		return 0, fmt.Errorf("invalid escape \\%c", s[0])
+	}
+	if len(s) <= digits {
+		return 0, fmt.Errorf("invalid escape \\%s", s)
+	}
+	r, err := strconv.ParseUint(s[1:1+digits], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return 0, fmt.Errorf("invalid escape \\%s", s[:1+digits])
+	}
+	buf.WriteRune(rune(r))
+	return digits, nil
+}
+
+// parseBlockScalar reads a literal or folded block scalar whose lines
+// are indented past parent.
+func (p *yamlParser) parseBlockScalar(header string, parent, line int) (*yamlNode, error) {
+	folded := header[0] == '>'
+	chomp := header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, yamlErrorf(line, "unsupported block scalar header %q", header)
+	}
+	var lines []string
+	indent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		text := strings.TrimLeft(raw, " ")
+		if text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		n := len(raw) - len(text)
+		if indent < 0 {
+			if n <= parent {
+				break
+			}
+			indent = n
+		}
+		if n < indent {
+			break
+		}
+		lines = append(lines, raw[indent:])
+	}
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	var buf bytes.Buffer
+	for i, l := range lines {
+		switch {
+		case i == 0:
+		case !folded:
+			buf.WriteByte('\n')
+		case l == "":
+			buf.WriteByte('\n')
+			continue
+		case lines[i-1] != "":
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(l)
+	}
+	if len(lines) > 0 {
+		switch chomp {
+		case "":
+			buf.WriteByte('\n')
+		case "+":
+			buf.WriteString(strings.Repeat("\n", trailing+1))
+		}
+	}
+	return &yamlNode{kind: yamlScalar, line: line, value: buf.String(), quoted: true}, nil
+}
+
+// decodeYAML stores n in v. It handles the kinds manifests are made of:
+// structs, string maps, slices, strings, ints and pointers to them.
+func decodeYAML(n *yamlNode, v reflect.Value) error {
+	if n.kind == yamlScalar && n.null {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeYAML(n, v.Elem())
+	case reflect.Struct:
+		if n.kind != yamlMapping {
+			return yamlErrorf(n.line, "expected a mapping")
+		}
+		fields := yamlFields(v.Type())
+		for i, key := range n.keys {
+			field, ok := fields[key]
+			if !ok {
+				return yamlErrorf(n.keyLines[i], "unknown field %q", key)
+			}
+			if err := decodeYAML(n.values[i], v.Field(field)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if n.kind != yamlMapping {
+			return yamlErrorf(n.line, "expected a mapping")
+		}
+		m := reflect.MakeMap(v.Type())
+		for i, key := range n.keys {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			// a null would silently become an empty string, which for an
+			// environment variable is a value of its own
+			if value := n.values[i]; value.kind == yamlScalar && value.null && elem.Kind() == reflect.String {
+				return yamlErrorf(n.keyLines[i], "%q has no value; quote an empty value as \"\"", key)
+			}
+			if err := decodeYAML(n.values[i], elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	case reflect.Slice:
+		if n.kind != yamlSequence {
+			return yamlErrorf(n.line, "expected a sequence")
+		}
+		s := reflect.MakeSlice(v.Type(), len(n.items), len(n.items))
+		for i, item := range n.items {
+			if err := decodeYAML(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.String:
+		if n.kind != yamlScalar {
+			return yamlErrorf(n.line, "expected a string")
+		}
+		v.SetString(n.value)
+	case reflect.Int:
+		i, err := strconv.Atoi(n.value)
+		if n.kind != yamlScalar || n.quoted || err != nil {
+			return yamlErrorf(n.line, "expected an integer")
+		}
+		v.SetInt(int64(i))
+	default:
+		return fmt.Errorf("yaml: cannot decode into %s", v.Type())
+	}
+	return nil
+}
+
+// yamlFields maps the json names of the fields of struct type t to their
+// index.
+func yamlFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
@@ -0,0 +1,217 @@
+package gondor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifestYAML(t *testing.T) {
+	src := `# production site
+---
+site: acme
+env:
+  DEBUG: "false"
+  EMPTY: ""
+  GREETING: 'it''s # not a comment'   # but this is
+  PATH_LIKE: /usr/bin:/bin
+instances:
+- label: primary
+  env: {}
+  services:
+    - name: web
+      kind: web
+      size: m
+      replicas: 2
+      env:
+        WORKERS: "4"
+    - name: db
+      kind: postgresql
+      version: "9.6"
+  hosts:
+  - host: www.example.com
+    keypair: wildcard
+  scheduled_tasks:
+  - name: nightly
+    schedule: "0 3 * * *"
+    command: |
+      python manage.py cleanup
+      python manage.py report
+- label: staging
+  services: []
+...
+`
+	m, err := LoadManifest(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	two := 2
+	want := &Manifest{
+		Site: "acme",
+		Env: map[string]string{
+			"DEBUG":     "false",
+			"EMPTY":     "",
+			"GREETING":  "it's # not a comment",
+			"PATH_LIKE": "/usr/bin:/bin",
+		},
+		Instances: []ManifestInstance{
+			{
+				Label: "primary",
+				Env:   map[string]string{},
+				Services: []ManifestService{
+					{Name: "web", Kind: "web", Size: "m", Replicas: &two, Env: map[string]string{"WORKERS": "4"}},
+					{Name: "db", Kind: "postgresql", Version: "9.6"},
+				},
+				Hosts: []ManifestHost{{Host: "www.example.com", KeyPair: "wildcard"}},
+				ScheduledTasks: []ManifestScheduledTask{{
+					Name:     "nightly",
+					Schedule: "0 3 * * *",
+					Command:  "python manage.py cleanup\npython manage.py report\n",
+				}},
+			},
+			{Label: "staging", Services: []ManifestService{}},
+		},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+}
+
+func TestUnmarshalYAMLScalars(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]string
+	}{
+		{"plain", "a: b c", map[string]string{"a": "b c"}},
+		{"trailing comment", "a: b # c", map[string]string{"a": "b"}},
+		{"hash inside a word", "a: b#c", map[string]string{"a": "b#c"}},
+		{"colon inside a value", "a: http://example.com:80/", map[string]string{"a": "http://example.com:80/"}},
+		{"double quoted escapes", `a: "tab\tnewline\nquote\"unicode\u00e9"`, map[string]string{"a": "tab\tnewline\nquote\"unicodeé"}},
+		{"single quoted backslash", `a: 'C:\path'`, map[string]string{"a": `C:\path`}},
+		{"quoted number stays a string", `a: "0123"`, map[string]string{"a": "0123"}},
+		{"quoted null", `a: "null"`, map[string]string{"a": "null"}},
+		{"quoted key", `"a b": c`, map[string]string{"a b": "c"}},
+		{"literal block", "a: |\n  one\n\n  two\nb: x", map[string]string{"a": "one\n\ntwo\n", "b": "x"}},
+		{"literal block strip", "a: |-\n  one\n  two\n", map[string]string{"a": "one\ntwo"}},
+		{"literal block keep", "a: |+\n  one\n\n", map[string]string{"a": "one\n\n"}},
+		{"folded block", "a: >\n  one\n  two\n\n  three\n", map[string]string{"a": "one two\nthree\n"}},
+		{"CRLF", "a: b\r\nc: 'd'\r\n", map[string]string{"a": "b", "c": "d"}},
+	}
+	for _, tt := range tests {
+		var got map[string]string
+		if err := unmarshalYAML([]byte(tt.src), &got); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalYAMLNested(t *testing.T) {
+	type inner struct {
+		Name  string            `json:"name"`
+		Tags  []string          `json:"tags"`
+		Count *int              `json:"count"`
+		Meta  map[string]string `json:"meta"`
+	}
+	var got struct {
+		Items  []inner             `json:"items"`
+		Nested map[string]*inner   `json:"nested"`
+		Matrix [][]string          `json:"matrix"`
+		Empty  *inner              `json:"empty"`
+		Lists  map[string][]string `json:"lists"`
+	}
+	src := `
+items:
+  - name: a
+    tags:
+    - x
+    - y
+    count: 3
+  - name: b
+    tags: []
+nested:
+  first:
+    name: c
+    meta:
+      k: v
+matrix:
+  - - 1
+    - 2
+  -
+    - 3
+empty:
+lists:
+  one:
+    - "x"
+`
+	if err := unmarshalYAML([]byte(src), &got); err != nil {
+		t.Fatal(err)
+	}
+	three := 3
+	want := got
+	want.Items = []inner{{Name: "a", Tags: []string{"x", "y"}, Count: &three}, {Name: "b", Tags: []string{}}}
+	want.Nested = map[string]*inner{"first": {Name: "c", Meta: map[string]string{"k": "v"}}}
+	want.Matrix = [][]string{{"1", "2"}, {"3"}}
+	want.Empty = nil
+	want.Lists = map[string][]string{"one": {"x"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalYAMLErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"site: a\nsite: b\n", `line 2: duplicate key "site"`},
+		{"site: a\n  env: b\n", "line 2: unexpected indentation"},
+		{"site: a\nbogus: b\n", `line 2: unknown field "bogus"`},
+		{"site: a\ninstances:\n- label: x\n  lable: y\n", `line 4: unknown field "lable"`},
+		{"site: a\nenv:\n  A: 1\n  B:\n", `line 4: "B" has no value; quote an empty value as ""`},
+		{"site: a\nenv:\n  A: ~\n", `line 3: "A" has no value; quote an empty value as ""`},
+		{"site: a\nenv:\n  A: null # unset\n", `line 3: "A" has no value; quote an empty value as ""`},
+		{"site: a\nenv:\n  A: \"open\n", "line 3: unterminated double quoted value"},
+		{"site: a\nenv:\n  A: 'open\n", "line 3: unterminated single quoted value"},
+		{"site: a\nenv:\n  A: \"x\" y\n", `line 3: unexpected "y" after quoted value`},
+		{"site: a\nenv:\n  A: \"\\q\"\n", `line 3: invalid escape \q`},
+		{"site: a\nenv: [A]\n", "line 2: flow collections are not supported"},
+		{"site: &anchor a\n", "line 1: anchors, aliases and tags are not supported"},
+		{"site: a\nenv:\n\tA: b\n", "line 3: tabs cannot be used for indentation"},
+		{"site: a\n---\nsite: b\n", "line 2: multiple documents are not supported"},
+		{"site: a\ninstances: x\n", "line 2: expected a sequence"},
+		{"site: a\nenv:\n- x\n", "line 3: expected a mapping"},
+		{"site: a\ninstances:\n- label: x\n  services:\n  - name: web\n    replicas: two\n", "line 6: expected an integer"},
+		{"site: a\ninstances:\n- label: x\n  services:\n  - name: web\n    replicas: \"2\"\n", "line 6: expected an integer"},
+		{"# comment\n\nsite: a\njust text\n", "line 4: expected a mapping key"},
+	}
+	for _, tt := range tests {
+		var m Manifest
+		err := unmarshalYAML([]byte(tt.src), &m)
+		if err == nil {
+			t.Errorf("%q: expected an error", tt.src)
+			continue
+		}
+		if want := "yaml: " + tt.want; err.Error() != want {
+			t.Errorf("%q: got error %q, want %q", tt.src, err, want)
+		}
+	}
+}
+
+func TestLoadManifestRejectsUnknownKindsAndSizes(t *testing.T) {
+	src := "site: a\ninstances:\n- label: x\n  services:\n  - name: web\n    kind: webb\n    size: huge\n"
+	_, err := LoadManifest(strings.NewReader(src))
+	fields, ok := ErrorFields(err)
+	if !ok {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	for _, field := range []string{"instances[0].services[0].kind", "instances[0].services[0].size"} {
+		if len(fields[field]) == 0 {
+			t.Errorf("no error for %s in %v", field, fields)
+		}
+	}
+}
@@ -1,19 +1,126 @@
 package gondor
 
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
 type MetricResource struct {
 	client *Client
 }
 
 type MetricSeries struct {
-	Columns []string `json:"columns"`
-	Name    *string  `json:"name"`
-	Points  [][]int  `json:"points"`
+	Columns []string    `json:"columns"`
+	Name    *string     `json:"name"`
+	Points  [][]float64 `json:"points"`
+}
+
+// MetricPoint is a single sample of a metric series.
+type MetricPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// MetricAggregation is how samples are combined into each step of a
+// metric query.
+type MetricAggregation string
+
+const (
+	MetricMean MetricAggregation = "mean"
+	MetricMin  MetricAggregation = "min"
+	MetricMax  MetricAggregation = "max"
+	MetricSum  MetricAggregation = "sum"
+)
+
+// MetricQuery selects the metrics returned by MetricResource.Query.
+type MetricQuery struct {
+	// Service is the URL of the service to query.
+	Service string
+	// Start and End bound the time range; zero values leave the API
+	// defaults.
+	Start time.Time
+	End   time.Time
+	// Step is the resolution of the returned points.
+	Step time.Duration
+	// Metrics restricts the result to the named series.
+	Metrics []string
+	// Aggregation combines the samples within each step.
+	Aggregation MetricAggregation
+}
+
+func (q MetricQuery) validate() error {
+	if q.Service == "" {
+		return errors.New("metric query: service is required")
+	}
+	if !q.Start.IsZero() && !q.End.IsZero() && !q.End.After(q.Start) {
+		return errors.New("metric query: end must be after start")
+	}
+	if q.Step < 0 {
+		return errors.New("metric query: step must not be negative")
+	}
+	switch q.Aggregation {
+	case "", MetricMean, MetricMin, MetricMax, MetricSum:
+	default:
+		return fmt.Errorf("metric query: unknown aggregation %q", q.Aggregation)
+	}
+	return nil
+}
+
+// MetricResult is a metric series with its points decoded.
+type MetricResult struct {
+	Name   string
+	Points []MetricPoint
 }
 
 func (r *MetricResource) List(serviceURL string) ([]*MetricSeries, error) {
+	return r.list(MetricQuery{Service: serviceURL})
+}
+
+// Query returns the metrics selected by q with typed points.
+func (r *MetricResource) Query(q MetricQuery) ([]*MetricResult, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	series, err := r.list(q)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*MetricResult, 0, len(series))
+	for _, s := range series {
+		points, err := s.MetricPoints()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &MetricResult{
+			Name:   stringValue(s.Name),
+			Points: points,
+		})
+	}
+	return res, nil
+}
+
+func (r *MetricResource) list(mq MetricQuery) ([]*MetricSeries, error) {
 	url := r.client.buildBaseURL("metrics/")
 	q := url.Query()
-	q.Add("service", serviceURL)
+	q.Add("service", mq.Service)
+	if !mq.Start.IsZero() {
+		q.Add("start", mq.Start.UTC().Format(time.RFC3339))
+	}
+	if !mq.End.IsZero() {
+		q.Add("end", mq.End.UTC().Format(time.RFC3339))
+	}
+	if mq.Step > 0 {
+		q.Add("step", strconv.Itoa(int(math.Ceil(mq.Step.Seconds()))))
+	}
+	for _, name := range mq.Metrics {
+		q.Add("metric", name)
+	}
+	if mq.Aggregation != "" {
+		q.Add("aggregation", string(mq.Aggregation))
+	}
 	url.RawQuery = q.Encode()
 	var res []*MetricSeries
 	_, err := r.client.Get(url, &res)
@@ -22,3 +129,53 @@ func (r *MetricResource) List(serviceURL string) ([]*MetricSeries, error) {
 	}
 	return res, nil
 }
+
+// Column returns the index of the named column, or -1.
+func (s *MetricSeries) Column(name string) int {
+	for i, c := range s.Columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// MetricPoints decodes Points using the "time" and "value" columns. If
+// there is no "value" column the last non-time column is used.
+func (s *MetricSeries) MetricPoints() ([]MetricPoint, error) {
+	timeCol := s.Column("time")
+	valueCol := s.Column("value")
+	if valueCol < 0 {
+		for i := len(s.Columns) - 1; i >= 0; i-- {
+			if i != timeCol {
+				valueCol = i
+				break
+			}
+		}
+	}
+	if valueCol < 0 {
+		return nil, fmt.Errorf("metric %q has no value column", stringValue(s.Name))
+	}
+	points := make([]MetricPoint, 0, len(s.Points))
+	for _, p := range s.Points {
+		if valueCol >= len(p) || timeCol >= len(p) {
+			return nil, fmt.Errorf("metric %q has a point with %d of %d columns", stringValue(s.Name), len(p), len(s.Columns))
+		}
+		var point MetricPoint
+		if timeCol >= 0 {
+			point.Time = metricTime(p[timeCol])
+		}
+		point.Value = p[valueCol]
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// metricTime converts an epoch timestamp in seconds or milliseconds.
+func metricTime(v float64) time.Time {
+	if v > 1e11 {
+		return time.Unix(0, int64(v*float64(time.Millisecond))).UTC()
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+}
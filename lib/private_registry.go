@@ -0,0 +1,172 @@
+package gondor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RegistryAuth holds credentials for a private Docker registry, either
+// as an entry of a Docker config.json "auths" map or as a username and
+// password.
+type RegistryAuth struct {
+	// Auth is base64 encoded "username:password", as written by
+	// docker login.
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// String describes the credentials without revealing the password.
+func (a RegistryAuth) String() string {
+	username, _, err := a.credentials()
+	if err != nil {
+		return "RegistryAuth(invalid)"
+	}
+	return fmt.Sprintf("RegistryAuth(%s:[REDACTED])", username)
+}
+
+// GoString keeps %#v from printing the password.
+func (a RegistryAuth) GoString() string {
+	return a.String()
+}
+
+// credentials returns the username and password, decoding Auth if set.
+func (a RegistryAuth) credentials() (string, string, error) {
+	if a.Auth == "" {
+		if a.Username == "" || a.Password == "" {
+			return "", "", fmt.Errorf("registry auth needs either auth or a username and password")
+		}
+		return a.Username, a.Password, nil
+	}
+	if a.Username != "" || a.Password != "" {
+		return "", "", fmt.Errorf("registry auth cannot set both auth and a username or password")
+	}
+	b, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("registry auth is not valid base64")
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("registry auth must encode username:password")
+	}
+	return parts[0], parts[1], nil
+}
+
+// key returns the private registry key sent to the API: the config.json
+// auth entry with the credentials in auth form.
+func (a RegistryAuth) key() ([]byte, error) {
+	username, password, err := a.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(RegistryAuth{
+		Auth:  base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+		Email: a.Email,
+	})
+}
+
+// ParseDockerConfig returns the credentials for registry from a Docker
+// config.json, or from a legacy .dockercfg. Entries are matched on the
+// registry host, so "https://registry.example.com/v1/" matches
+// "registry.example.com".
+func ParseDockerConfig(config []byte, registry string) (RegistryAuth, error) {
+	var doc struct {
+		Auths map[string]RegistryAuth `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &doc); err != nil {
+		return RegistryAuth{}, fmt.Errorf("docker config: %s", err)
+	}
+	auths := doc.Auths
+	if auths == nil {
+		// .dockercfg files hold the auths map at the top level
+		if err := json.Unmarshal(config, &auths); err != nil {
+			return RegistryAuth{}, fmt.Errorf("docker config: no auths found")
+		}
+	}
+	want := registryHost(registry)
+	for name, auth := range auths {
+		if name == registry || registryHost(name) == want {
+			if _, _, err := auth.credentials(); err != nil {
+				return RegistryAuth{}, fmt.Errorf("docker config: %s: %s", name, err)
+			}
+			return auth, nil
+		}
+	}
+	return RegistryAuth{}, fmt.Errorf("docker config: no credentials for %s", registry)
+}
+
+// registryHost returns the host[:port] of a registry given with or
+// without a scheme and path.
+func registryHost(registry string) string {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	u, err := url.Parse(registry)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+func validateRegistryURL(registryURL string) error {
+	raw := registryURL
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url errors quote the input, which may hold credentials
+		return fmt.Errorf("invalid registry URL")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("invalid registry URL %q: scheme must be http or https", registryURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid registry URL %q: missing host", registryURL)
+	}
+	if u.User != nil {
+		// never echo the URL back since it holds credentials
+		return fmt.Errorf("invalid registry URL: credentials must be given as registry auth, not in the URL")
+	}
+	return nil
+}
+
+// SetPrivateRegistry makes site pull images from the registry at
+// registryURL using auth.
+func (r *SiteResource) SetPrivateRegistry(site *Site, registryURL string, auth RegistryAuth) error {
+	if err := validateRegistryURL(registryURL); err != nil {
+		return err
+	}
+	key, err := auth.key()
+	if err != nil {
+		return err
+	}
+	if err := r.Update(Site{URL: site.URL, PrivateRegistryURL: &registryURL, PrivateRegistryKey: key}); err != nil {
+		return err
+	}
+	site.PrivateRegistryURL = &registryURL
+	site.PrivateRegistryKey = key
+	return nil
+}
+
+// ClearPrivateRegistry removes the private registry and its credentials
+// from site.
+func (r *SiteResource) ClearPrivateRegistry(site *Site) error {
+	u, _ := url.Parse(*site.URL)
+	// omitempty would drop nil fields from a Site, so send explicit nulls
+	req := map[string]interface{}{
+		"private_registry_url": nil,
+		"private_registry_key": nil,
+	}
+	_, err := r.client.Patch(u, req, nil)
+	if err != nil {
+		return err
+	}
+	site.PrivateRegistryURL = nil
+	site.PrivateRegistryKey = nil
+	return nil
+}
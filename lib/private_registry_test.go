@@ -0,0 +1,111 @@
+package gondor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// bob:hunter2
+const testRegistryAuth = "Ym9iOmh1bnRlcjI="
+
+func TestRegistryAuthCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     RegistryAuth
+		username string
+		password string
+		err      string
+	}{
+		{"auth", RegistryAuth{Auth: testRegistryAuth}, "bob", "hunter2", ""},
+		{"username and password", RegistryAuth{Username: "bob", Password: "hunter2"}, "bob", "hunter2", ""},
+		{"colon in password", RegistryAuth{Auth: "Ym9iOmh1bjp0ZXI="}, "bob", "hun:ter", ""},
+		{"empty", RegistryAuth{}, "", "", "registry auth needs either auth or a username and password"},
+		{"username only", RegistryAuth{Username: "bob"}, "", "", "registry auth needs either auth or a username and password"},
+		{"both", RegistryAuth{Auth: testRegistryAuth, Username: "bob"}, "", "", "registry auth cannot set both auth and a username or password"},
+		{"bad base64", RegistryAuth{Auth: "not base64!"}, "", "", "registry auth is not valid base64"},
+		{"no colon", RegistryAuth{Auth: "Ym9i"}, "", "", "registry auth must encode username:password"},
+		{"empty password", RegistryAuth{Auth: "Ym9iOg=="}, "", "", "registry auth must encode username:password"},
+	}
+	for _, tt := range tests {
+		username, password, err := tt.auth.credentials()
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if username != tt.username || password != tt.password {
+			t.Errorf("%s: got %q:%q, want %q:%q", tt.name, username, password, tt.username, tt.password)
+		}
+	}
+}
+
+func TestParseDockerConfig(t *testing.T) {
+	config := `{"auths": {
+		"https://registry.example.com/v1/": {"auth": "` + testRegistryAuth + `", "email": "bob@example.com"},
+		"other.example.com:5000": {"username": "alice", "password": "secret"},
+		"broken.example.com": {"auth": "Ym9i"}
+	}}`
+	legacy := `{"registry.example.com": {"auth": "` + testRegistryAuth + `"}}`
+	tests := []struct {
+		name     string
+		config   string
+		registry string
+		username string
+		err      string
+	}{
+		{"host matches url entry", config, "registry.example.com", "bob", ""},
+		{"url matches url entry", config, "https://registry.example.com/v2/", "bob", ""},
+		{"host case", config, "Registry.Example.com", "bob", ""},
+		{"port", config, "other.example.com:5000", "alice", ""},
+		{"port must match", config, "other.example.com", "", "docker config: no credentials for other.example.com"},
+		{"legacy dockercfg", legacy, "registry.example.com", "bob", ""},
+		{"invalid entry", config, "broken.example.com", "", "docker config: broken.example.com: registry auth must encode username:password"},
+		{"missing", config, "missing.example.com", "", "docker config: no credentials for missing.example.com"},
+		{"not json", "auths", "registry.example.com", "", "docker config: invalid character 'a' looking for beginning of value"},
+		{"no auths", `{"credsStore": "desktop"}`, "registry.example.com", "", "docker config: no auths found"},
+	}
+	for _, tt := range tests {
+		auth, err := ParseDockerConfig([]byte(tt.config), tt.registry)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if username, _, _ := auth.credentials(); username != tt.username {
+			t.Errorf("%s: got user %q, want %q", tt.name, username, tt.username)
+		}
+	}
+}
+
+func TestRegistryAuthFormatting(t *testing.T) {
+	auths := []RegistryAuth{
+		{Auth: testRegistryAuth, Email: "bob@example.com"},
+		{Username: "bob", Password: "hunter2"},
+		{Password: "hunter2"},
+	}
+	for _, auth := range auths {
+		for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+			for _, arg := range []interface{}{auth, &auth, []RegistryAuth{auth}} {
+				out := fmt.Sprintf(verb, arg)
+				if strings.Contains(out, "hunter2") || strings.Contains(out, testRegistryAuth) {
+					t.Errorf("%s of %T reveals the password: %s", verb, arg, out)
+				}
+			}
+		}
+	}
+	if got := fmt.Sprint(auths[0]); got != "RegistryAuth(bob:[REDACTED])" {
+		t.Errorf("got %q", got)
+	}
+	if got := fmt.Sprint(auths[2]); got != "RegistryAuth(invalid)" {
+		t.Errorf("got %q", got)
+	}
+}
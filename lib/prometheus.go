@@ -0,0 +1,297 @@
+package gondor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsTarget is a service scraped by a PrometheusExporter, with the
+// label values its samples are exported under.
+type MetricsTarget struct {
+	ServiceURL string
+	Site       string
+	Instance   string
+	Service    string
+}
+
+// NewMetricsTarget labels a service with its site and instance names.
+func NewMetricsTarget(site *Site, instance *Instance, service *Service) MetricsTarget {
+	return MetricsTarget{
+		ServiceURL: stringValue(service.URL),
+		Site:       stringValue(site.Name),
+		Instance:   stringValue(instance.Label),
+		Service:    stringValue(service.Name),
+	}
+}
+
+func (t MetricsTarget) labels() string {
+	return fmt.Sprintf(
+		`site="%s",instance="%s",service="%s"`,
+		escapeLabelValue(t.Site),
+		escapeLabelValue(t.Instance),
+		escapeLabelValue(t.Service),
+	)
+}
+
+// apiLatencyBuckets are the upper bounds, in seconds, of the API latency
+// histogram.
+var apiLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type metricSample struct {
+	name  string
+	value float64
+}
+
+// PrometheusExporter periodically scrapes Gondor service metrics and
+// serves them in the Prometheus text exposition format. It also exports
+// scrape error counts and the latency of every API request made by its
+// client.
+type PrometheusExporter struct {
+	// Interval between scrapes; defaults to one minute.
+	Interval time.Duration
+	// Namespace prefixes every exported metric name; defaults to "gondor".
+	Namespace string
+
+	client  *Client
+	targets []MetricsTarget
+
+	mu           sync.Mutex
+	samples      map[MetricsTarget][]metricSample
+	scrapeErrors map[MetricsTarget]uint64
+	latency      map[string]*latencyHistogram
+}
+
+// NewPrometheusExporter returns an exporter scraping targets through
+// client. It registers a request observer on client to measure API
+// latency.
+func NewPrometheusExporter(client *Client, targets []MetricsTarget) *PrometheusExporter {
+	e := &PrometheusExporter{
+		Interval:     time.Minute,
+		Namespace:    "gondor",
+		client:       client,
+		targets:      targets,
+		samples:      make(map[MetricsTarget][]metricSample),
+		scrapeErrors: make(map[MetricsTarget]uint64),
+		latency:      make(map[string]*latencyHistogram),
+	}
+	client.AddRequestObserver(e.observe)
+	return e
+}
+
+func (e *PrometheusExporter) observe(method string, u *url.URL, status int, elapsed time.Duration, err error) {
+	labels := fmt.Sprintf(`method="%s",code="%d"`, escapeLabelValue(method), status)
+	seconds := elapsed.Seconds()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h, ok := e.latency[labels]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(apiLatencyBuckets))}
+		e.latency[labels] = h
+	}
+	for i, le := range apiLatencyBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Run scrapes every target each Interval until ctx is done.
+func (e *PrometheusExporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(durationOrDefault(e.Interval, time.Minute))
+	defer ticker.Stop()
+	for {
+		e.Scrape()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetTargets replaces the scraped targets, for example after services
+// were added or deleted. Samples and error counts of targets no longer
+// listed are dropped.
+func (e *PrometheusExporter) SetTargets(targets []MetricsTarget) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	keep := make(map[MetricsTarget]bool, len(targets))
+	for _, t := range targets {
+		keep[t] = true
+	}
+	for t := range e.samples {
+		if !keep[t] {
+			delete(e.samples, t)
+		}
+	}
+	for t := range e.scrapeErrors {
+		if !keep[t] {
+			delete(e.scrapeErrors, t)
+		}
+	}
+	e.targets = append([]MetricsTarget(nil), targets...)
+}
+
+// Scrape fetches the latest metrics of every target once. A target whose
+// scrape fails exports no samples until a scrape succeeds again, so a
+// deleted service does not keep reporting its last values.
+func (e *PrometheusExporter) Scrape() {
+	e.mu.Lock()
+	targets := e.targets
+	e.mu.Unlock()
+	for _, t := range targets {
+		series, err := e.client.Metrics.List(t.ServiceURL)
+		if err != nil {
+			e.mu.Lock()
+			delete(e.samples, t)
+			if e.listed(t) {
+				e.scrapeErrors[t]++
+			}
+			e.mu.Unlock()
+			continue
+		}
+		var samples []metricSample
+		failed := false
+		for _, s := range series {
+			points, err := s.MetricPoints()
+			if err != nil {
+				failed = true
+				continue
+			}
+			if len(points) == 0 {
+				continue
+			}
+			samples = append(samples, metricSample{
+				name:  stringValue(s.Name),
+				value: points[len(points)-1].Value,
+			})
+		}
+		e.mu.Lock()
+		if e.listed(t) {
+			e.samples[t] = samples
+			if failed {
+				e.scrapeErrors[t]++
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// listed reports whether t is still a target; SetTargets may have
+// dropped it while it was being scraped. e.mu must be held.
+func (e *PrometheusExporter) listed(t MetricsTarget) bool {
+	for _, target := range e.targets {
+		if target == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP writes the current state in the text exposition format.
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	e.writeMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// writeMetrics renders every exported metric into buf.
+func (e *PrometheusExporter) writeMetrics(buf *bytes.Buffer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ns := valueOrDefault(e.Namespace, "gondor")
+
+	// group service samples by metric name so each family is contiguous
+	families := make(map[string][]string)
+	for _, t := range e.targets {
+		for _, s := range e.samples[t] {
+			name := fmt.Sprintf("%s_service_%s", ns, sanitizeMetricName(s.name))
+			families[name] = append(families[name], fmt.Sprintf("%s{%s} %s", name, t.labels(), formatSampleValue(s.value)))
+		}
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", name)
+		for _, line := range families[name] {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	name := ns + "_exporter_scrape_errors_total"
+	fmt.Fprintf(buf, "# HELP %s Number of failed metric scrapes per service.\n", name)
+	fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+	for _, t := range e.targets {
+		fmt.Fprintf(buf, "%s{%s} %d\n", name, t.labels(), e.scrapeErrors[t])
+	}
+
+	name = ns + "_api_request_duration_seconds"
+	fmt.Fprintf(buf, "# HELP %s Latency of Gondor API requests.\n", name)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	keys := make([]string, 0, len(e.latency))
+	for k := range e.latency {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, labels := range keys {
+		h := e.latency[labels]
+		for i, le := range apiLatencyBuckets {
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatSampleValue(le), h.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, formatSampleValue(h.sum))
+		fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+// sanitizeMetricName maps s onto the characters allowed in a metric name.
+func sanitizeMetricName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, s)
+	return valueOrDefault(s, "unnamed")
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func formatSampleValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,204 @@
+package gondor
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxReviewAppLabel bounds generated instance labels.
+const maxReviewAppLabel = 40
+
+// ReviewApps manages ephemeral per-branch instances cloned from a
+// template instance on a site.
+type ReviewApps struct {
+	// LabelPrefix is prepended to every review app label so review apps
+	// can be told apart from other instances; defaults to "review-".
+	LabelPrefix string
+	// TTL is how long a review app may go without a build before Reap
+	// deletes it; defaults to one week.
+	TTL time.Duration
+	// Wait makes Deploy block until every deployed service is running.
+	Wait bool
+
+	client   *Client
+	site     *Site
+	template *Instance
+}
+
+// ReviewApp is a deployed review environment.
+type ReviewApp struct {
+	Branch   string
+	Instance *Instance
+	Build    *Build
+	WebURL   string
+}
+
+// NewReviewApps returns a manager creating review apps on site from
+// template.
+func NewReviewApps(client *Client, site *Site, template *Instance) *ReviewApps {
+	return &ReviewApps{
+		LabelPrefix: "review-",
+		TTL:         7 * 24 * time.Hour,
+		client:      client,
+		site:        site,
+		template:    template,
+	}
+}
+
+// Label derives a safe instance label from a branch name: lowercase
+// letters, digits and dashes, prefixed with LabelPrefix and suffixed with
+// a hash of the branch name, so branches differing only in characters
+// that are dropped or truncated still get distinct labels.
+func (m *ReviewApps) Label(branch string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(branch) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.Trim(b.String(), "-")
+	sum := sha1.Sum([]byte(branch))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	keep := maxReviewAppLabel - len(m.LabelPrefix) - len(suffix) - 1
+	if keep < 0 {
+		keep = 0
+	}
+	if len(name) > keep {
+		name = strings.TrimRight(name[:keep], "-")
+	}
+	if name != "" {
+		name += "-"
+	}
+	return m.LabelPrefix + name + suffix
+}
+
+func (m *ReviewApps) find(label string) (*Instance, error) {
+	instances, err := m.client.Instances.List(m.site.URL)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if stringValue(instance.Label) == label {
+			return instance, nil
+		}
+	}
+	return nil, nil
+}
+
+// Deploy creates the review app for branch if it does not exist yet,
+// builds tarball on it and deploys the build to its web and worker
+// services.
+func (m *ReviewApps) Deploy(branch string, tarball io.Reader) (*ReviewApp, error) {
+	label := m.Label(branch)
+	instance, err := m.find(label)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		if instance, err = m.template.Clone(label); err != nil {
+			return nil, fmt.Errorf("creating review app %q: %s", label, err)
+		}
+	}
+	build := &Build{
+		Site:     m.site.URL,
+		Instance: instance.URL,
+		Label:    &label,
+		Ref:      &branch,
+	}
+	if err := m.client.Builds.Create(build); err != nil {
+		return nil, err
+	}
+	if _, err := build.Perform(tarball); err != nil {
+		return nil, err
+	}
+	services, err := m.client.Services.List(instance.URL)
+	if err != nil {
+		return nil, err
+	}
+	app := &ReviewApp{
+		Branch:   branch,
+		Instance: instance,
+		Build:    build,
+		WebURL:   stringValue(instance.WebURL),
+	}
+	for _, service := range services {
+		switch ServiceKind(stringValue(service.Kind)) {
+		case ServiceKindWeb:
+			if app.WebURL == "" {
+				app.WebURL = stringValue(service.WebURL)
+			}
+		case ServiceKindWorker:
+		default:
+			continue
+		}
+		deployment := &Deployment{
+			Service: service.URL,
+			Build:   build.URL,
+		}
+		if err := m.client.Deployments.Create(deployment); err != nil {
+			return app, fmt.Errorf("deploying service %q: %s", stringValue(service.Name), err)
+		}
+		if m.Wait {
+			if err := deployment.Wait(); err != nil {
+				return app, fmt.Errorf("deploying service %q: %s", stringValue(service.Name), err)
+			}
+		}
+	}
+	return app, nil
+}
+
+// Teardown deletes the review app for branch, if any.
+func (m *ReviewApps) Teardown(branch string) error {
+	instance, err := m.find(m.Label(branch))
+	if err != nil || instance == nil {
+		return err
+	}
+	return m.client.Instances.Delete(*instance.URL)
+}
+
+// Reap deletes every review app whose most recent build is older than
+// TTL as of now and returns the labels deleted. Review apps that have
+// never been built are kept: instances carry no creation time, so their
+// age cannot be told.
+func (m *ReviewApps) Reap(now time.Time) ([]string, error) {
+	instances, err := m.client.Instances.List(m.site.URL)
+	if err != nil {
+		return nil, err
+	}
+	var reaped []string
+	for _, instance := range instances {
+		label := stringValue(instance.Label)
+		if m.LabelPrefix == "" || !strings.HasPrefix(label, m.LabelPrefix) {
+			continue
+		}
+		if instance.URL != nil && m.template.URL != nil && *instance.URL == *m.template.URL {
+			continue
+		}
+		builds, err := m.client.Builds.List(nil, instance.URL, 1)
+		if err != nil {
+			return reaped, err
+		}
+		if len(builds) == 0 {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339Nano, stringValue(builds[0].Created))
+		// without a usable build time the app is kept
+		if err != nil || now.Sub(created) < m.TTL {
+			continue
+		}
+		if err := m.client.Instances.Delete(*instance.URL); err != nil {
+			return reaped, err
+		}
+		reaped = append(reaped, label)
+	}
+	return reaped, nil
+}
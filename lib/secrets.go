@@ -0,0 +1,277 @@
+package gondor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// secretRefPrefix starts env var values referring to a secret.
+const secretRefPrefix = "secret://"
+
+// SecretRef returns the env var value referring to the secret name.
+func SecretRef(name string) string {
+	return secretRefPrefix + name
+}
+
+// ParseSecretRef returns the secret name value refers to, if it is a
+// secret reference.
+func ParseSecretRef(value string) (string, bool) {
+	if !strings.HasPrefix(value, secretRefPrefix) || len(value) == len(secretRefPrefix) {
+		return "", false
+	}
+	return value[len(secretRefPrefix):], true
+}
+
+// SecretBackend looks up secret values by name.
+type SecretBackend interface {
+	Secret(name string) (string, error)
+}
+
+// SetSecretBackend makes the client resolve secret:// references in env
+// var values through b before sending them.
+func (c *Client) SetSecretBackend(b SecretBackend) {
+	c.secrets = b
+}
+
+// resolveSecret returns value with a secret reference replaced by the
+// secret it names. Other values are returned unchanged.
+func (c *Client) resolveSecret(value string) (string, error) {
+	name, ok := ParseSecretRef(value)
+	if !ok {
+		return value, nil
+	}
+	if c.secrets == nil {
+		return "", fmt.Errorf("cannot resolve %s: no secret backend configured", value)
+	}
+	secret, err := c.secrets.Secret(name)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %s", value, err)
+	}
+	return secret, nil
+}
+
+// resolveSecrets returns envVars with secret references resolved. The
+// variables are copied only if a reference is found so the caller's
+// values keep their references.
+func (c *Client) resolveSecrets(envVars []*EnvironmentVariable) ([]*EnvironmentVariable, error) {
+	var resolved []*EnvironmentVariable
+	for i, ev := range envVars {
+		if _, ok := ParseSecretRef(stringValue(ev.Value)); !ok {
+			continue
+		}
+		if resolved == nil {
+			resolved = make([]*EnvironmentVariable, len(envVars))
+			copy(resolved, envVars)
+		}
+		value, err := c.resolveSecret(*ev.Value)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %q: %s", stringValue(ev.Key), err)
+		}
+		cp := *ev
+		cp.Value = &value
+		resolved[i] = &cp
+	}
+	if resolved == nil {
+		return envVars, nil
+	}
+	return resolved, nil
+}
+
+// EnvSecretBackend reads secrets from the process environment. A secret
+// name is upper-cased, every character other than a letter or digit is
+// replaced by an underscore and Prefix is prepended, so with the prefix
+// "SECRET_" the secret db/password is read from SECRET_DB_PASSWORD.
+type EnvSecretBackend struct {
+	Prefix string
+}
+
+func (b EnvSecretBackend) Secret(name string) (string, error) {
+	key := b.Prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%s is not set", key)
+	}
+	return value, nil
+}
+
+// FileSecretBackend stores secrets in a local file encrypted with
+// AES-256-GCM.
+type FileSecretBackend struct {
+	path string
+	aead cipher.AEAD
+
+	mu sync.Mutex
+}
+
+type secretFile struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewFileSecretBackend opens the secret store at path, which is created
+// on the first Set. key must be 32 bytes long.
+func NewFileSecretBackend(path string, key []byte) (*FileSecretBackend, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret store key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSecretBackend{path: path, aead: aead}, nil
+}
+
+func (b *FileSecretBackend) Secret(name string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	secrets, err := b.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q was not found", name)
+	}
+	return value, nil
+}
+
+// Set stores value as the secret name, replacing any previous value.
+func (b *FileSecretBackend) Set(name, value string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	secrets, err := b.load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return b.save(secrets)
+}
+
+// Delete removes the secret name.
+func (b *FileSecretBackend) Delete(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	secrets, err := b.load()
+	if err != nil {
+		return err
+	}
+	delete(secrets, name)
+	return b.save(secrets)
+}
+
+func (b *FileSecretBackend) load() (map[string]string, error) {
+	data, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f secretFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("secret store %s: %s", b.path, err)
+	}
+	plaintext, err := b.aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("secret store %s: cannot decrypt, wrong key or corrupt file", b.path)
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("secret store %s: %s", b.path, err)
+	}
+	return secrets, nil
+}
+
+func (b *FileSecretBackend) save(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	f := secretFile{Nonce: make([]byte, b.aead.NonceSize())}
+	if _, err := io.ReadFull(rand.Reader, f.Nonce); err != nil {
+		return err
+	}
+	f.Ciphertext = b.aead.Seal(nil, f.Nonce, plaintext, nil)
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(b.path, data); err != nil {
+		return err
+	}
+	return os.Chmod(b.path, 0600)
+}
+
+// RotateSecret pushes the current value of the secret name to every env
+// var in m that refers to it, leaving the rest of the site untouched. It
+// returns the executed plan; its steps are empty if every reference was
+// already up to date.
+func (c *Client) RotateSecret(m *Manifest, name string) (*Plan, error) {
+	ref := SecretRef(name)
+	refs := func(env map[string]string) map[string]string {
+		var out map[string]string
+		for k, v := range env {
+			if v == ref {
+				if out == nil {
+					out = make(map[string]string)
+				}
+				out[k] = v
+			}
+		}
+		return out
+	}
+	filtered := &Manifest{
+		Site:          m.Site,
+		ResourceGroup: m.ResourceGroup,
+		Env:           refs(m.Env),
+	}
+	for _, mi := range m.Instances {
+		fi := mi
+		fi.Env = refs(mi.Env)
+		fi.Services = nil
+		fi.Hosts = nil
+		fi.ScheduledTasks = nil
+		for _, ms := range mi.Services {
+			ms.Env = refs(ms.Env)
+			fi.Services = append(fi.Services, ms)
+		}
+		filtered.Instances = append(filtered.Instances, fi)
+	}
+	plan, err := c.PlanManifest(filtered, ApplyOpts{})
+	if err != nil {
+		return nil, err
+	}
+	rotation := &Plan{}
+	for _, step := range plan.Steps {
+		if step.Resource != "env" {
+			// only variables that already exist can be rotated; their
+			// parents must not be created behind the caller's back
+			if step.Action == PlanCreate {
+				return nil, fmt.Errorf("%s %s does not exist; apply the manifest first", step.Resource, step.Path)
+			}
+			continue
+		}
+		rotation.Steps = append(rotation.Steps, step)
+	}
+	return rotation, rotation.Execute()
+}
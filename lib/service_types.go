@@ -0,0 +1,227 @@
+package gondor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ServiceKind is the kind of process a service runs.
+type ServiceKind string
+
+const (
+	ServiceKindWeb           ServiceKind = "web"
+	ServiceKindWorker        ServiceKind = "worker"
+	ServiceKindPostgreSQL    ServiceKind = "postgresql"
+	ServiceKindMySQL         ServiceKind = "mysql"
+	ServiceKindRedis         ServiceKind = "redis"
+	ServiceKindMemcached     ServiceKind = "memcached"
+	ServiceKindElasticsearch ServiceKind = "elasticsearch"
+	ServiceKindRabbitMQ      ServiceKind = "rabbitmq"
+)
+
+var serviceKinds = []ServiceKind{
+	ServiceKindWeb,
+	ServiceKindWorker,
+	ServiceKindPostgreSQL,
+	ServiceKindMySQL,
+	ServiceKindRedis,
+	ServiceKindMemcached,
+	ServiceKindElasticsearch,
+	ServiceKindRabbitMQ,
+}
+
+// ParseServiceKind returns the ServiceKind named by s.
+func ParseServiceKind(s string) (ServiceKind, error) {
+	for _, kind := range serviceKinds {
+		if string(kind) == s {
+			return kind, nil
+		}
+	}
+	names := make([]string, len(serviceKinds))
+	for i := range serviceKinds {
+		names[i] = string(serviceKinds[i])
+	}
+	return "", fmt.Errorf("unknown service kind %q; must be one of %s", s, strings.Join(names, ", "))
+}
+
+// Stateful reports whether services of this kind keep data on a volume.
+func (kind ServiceKind) Stateful() bool {
+	switch kind {
+	case ServiceKindWeb, ServiceKindWorker, ServiceKindMemcached:
+		return false
+	}
+	return true
+}
+
+// ServiceSize is the instance size a service's containers run on.
+type ServiceSize string
+
+const (
+	ServiceSizeXS ServiceSize = "xs"
+	ServiceSizeS  ServiceSize = "s"
+	ServiceSizeM  ServiceSize = "m"
+	ServiceSizeL  ServiceSize = "l"
+	ServiceSizeXL ServiceSize = "xl"
+)
+
+var serviceSizes = []ServiceSize{
+	ServiceSizeXS,
+	ServiceSizeS,
+	ServiceSizeM,
+	ServiceSizeL,
+	ServiceSizeXL,
+}
+
+// ParseServiceSize returns the ServiceSize named by s.
+func ParseServiceSize(s string) (ServiceSize, error) {
+	for _, size := range serviceSizes {
+		if string(size) == s {
+			return size, nil
+		}
+	}
+	names := make([]string, len(serviceSizes))
+	for i := range serviceSizes {
+		names[i] = string(serviceSizes[i])
+	}
+	return "", fmt.Errorf("unknown service size %q; must be one of %s", s, strings.Join(names, ", "))
+}
+
+// PortSpec is a single port or port range opened on a service.
+type PortSpec struct {
+	Start    int
+	End      int
+	Protocol string
+}
+
+func (p PortSpec) String() string {
+	ports := strconv.Itoa(p.Start)
+	if p.End != p.Start {
+		ports = fmt.Sprintf("%d-%d", p.Start, p.End)
+	}
+	return fmt.Sprintf("%s/%s", ports, p.Protocol)
+}
+
+// ParsePortSpec parses a port specification of the form PORT[-PORT][/PROTO].
+// The protocol is tcp or udp and defaults to tcp.
+func ParsePortSpec(s string) (PortSpec, error) {
+	spec := PortSpec{Protocol: "tcp"}
+	ports := strings.TrimSpace(s)
+	if i := strings.Index(ports, "/"); i >= 0 {
+		spec.Protocol = strings.ToLower(ports[i+1:])
+		ports = ports[:i]
+	}
+	if spec.Protocol != "tcp" && spec.Protocol != "udp" {
+		return spec, fmt.Errorf("port spec %q: protocol must be tcp or udp", s)
+	}
+	bounds := strings.SplitN(ports, "-", 2)
+	var err error
+	if spec.Start, err = parsePort(bounds[0]); err != nil {
+		return spec, fmt.Errorf("port spec %q: %s", s, err)
+	}
+	spec.End = spec.Start
+	if len(bounds) == 2 {
+		if spec.End, err = parsePort(bounds[1]); err != nil {
+			return spec, fmt.Errorf("port spec %q: %s", s, err)
+		}
+		if spec.End < spec.Start {
+			return spec, fmt.Errorf("port spec %q: range end is before its start", s)
+		}
+	}
+	return spec, nil
+}
+
+func parsePort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	if n < 1 || n > 65535 {
+		return 0, fmt.Errorf("port %d is out of range 1-65535", n)
+	}
+	return n, nil
+}
+
+// ParseOpenPorts parses a comma-separated list of port specifications as
+// accepted by Service.OpenPorts.
+func ParseOpenPorts(s string) ([]PortSpec, error) {
+	var specs []PortSpec
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		spec, err := ParsePortSpec(part)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// FormatOpenPorts renders specs in the form expected by Service.OpenPorts.
+func FormatOpenPorts(specs []PortSpec) string {
+	parts := make([]string, len(specs))
+	for i := range specs {
+		parts[i] = specs[i].String()
+	}
+	return strings.Join(parts, ",")
+}
+
+var serviceVersionRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// NewService returns a service ready to be passed to ServiceResource.Create.
+func NewService(instanceURL, name string, kind ServiceKind, size ServiceSize) *Service {
+	k, sz := string(kind), string(size)
+	return &Service{
+		Instance: &instanceURL,
+		Name:     &name,
+		Kind:     &k,
+		Size:     &sz,
+	}
+}
+
+// Validate checks s against the kinds, sizes and version formats known
+// to this package. Problems are reported as an APIError keyed by field
+// name, the same shape the API uses for a 400 response. Create and
+// Update call it before sending anything unless
+// ServiceResource.SkipValidation is set.
+func (s *Service) Validate() error {
+	return s.validate(true)
+}
+
+// validate checks s for malformed values and, if strict, for values
+// outside the known kinds, sizes and version formats.
+func (s *Service) validate(strict bool) error {
+	errList := ErrorList{}
+	if s.Kind != nil {
+		if *s.Kind == "" {
+			errList["kind"] = append(errList["kind"], "must not be empty")
+		} else if _, err := ParseServiceKind(*s.Kind); err != nil && strict {
+			errList["kind"] = append(errList["kind"], err.Error())
+		}
+	}
+	if s.Size != nil {
+		if *s.Size == "" {
+			errList["size"] = append(errList["size"], "must not be empty")
+		} else if _, err := ParseServiceSize(*s.Size); err != nil && strict {
+			errList["size"] = append(errList["size"], err.Error())
+		}
+	}
+	if s.Replicas != nil && *s.Replicas < 0 {
+		errList["replicas"] = append(errList["replicas"], "must not be negative")
+	}
+	if s.Version != nil && strict && !serviceVersionRe.MatchString(*s.Version) {
+		errList["version"] = append(errList["version"], fmt.Sprintf("%q is not a version number", *s.Version))
+	}
+	if s.OpenPorts != "" {
+		if _, err := ParseOpenPorts(s.OpenPorts); err != nil {
+			errList["open_ports"] = append(errList["open_ports"], err.Error())
+		}
+	}
+	if len(errList) > 0 {
+		return apiError{errList: errList}
+	}
+	return nil
+}
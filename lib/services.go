@@ -1,13 +1,20 @@
 package gondor
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type ServiceResource struct {
 	client *Client
+
+	// SkipValidation makes Create and Update accept kinds, sizes and
+	// versions this package does not know, such as ones the API has
+	// gained since. Malformed values are still rejected.
+	SkipValidation bool
 }
 
 type Service struct {
@@ -22,6 +29,8 @@ type Service struct {
 	State    *string           `json:"state,omitempty"`
 	Env      map[string]string `json:"env,omitempty"`
 	WebURL   *string           `json:"web_url,omitempty"`
+	// Restarted is when the service was last restarted.
+	Restarted *string `json:"restarted,omitempty"`
 
 	// create only
 	Version   *string `json:"version,omitempty"`
@@ -34,6 +43,14 @@ type Service struct {
 	URL *string `json:"url,omitempty"`
 
 	r *ServiceResource
+
+	// last lifecycle change requested through this value
+	wantState    ServiceState
+	wantReplicas *int
+	// a requested restart has not been seen to take effect yet, which
+	// it has once Restarted differs from restartMark
+	restartPending bool
+	restartMark    string
 }
 
 func (r *ServiceResource) findOne(url *url.URL) (*Service, error) {
@@ -50,6 +67,9 @@ func (r *ServiceResource) findOne(url *url.URL) (*Service, error) {
 }
 
 func (r *ServiceResource) Create(service *Service) error {
+	if err := service.validate(!r.SkipValidation); err != nil {
+		return err
+	}
 	url := r.client.buildBaseURL("services/")
 	_, err := r.client.Post(url, service, service)
 	if err != nil {
@@ -94,6 +114,9 @@ func (r *ServiceResource) List(instanceURL *string) ([]*Service, error) {
 }
 
 func (r *ServiceResource) Update(service Service) error {
+	if err := service.validate(!r.SkipValidation); err != nil {
+		return err
+	}
 	u, _ := url.Parse(*service.URL)
 	service.URL = nil
 	_, err := r.client.Patch(u, &service, nil)
@@ -112,23 +135,117 @@ func (r *ServiceResource) Delete(serviceURL string) error {
 	return nil
 }
 
+// ServiceState is the lifecycle state of a service.
+type ServiceState string
+
+const (
+	ServiceRunning   ServiceState = "running"
+	ServiceStopped   ServiceState = "stopped"
+	ServiceRestarted ServiceState = "restarted"
+	ServiceDeploying ServiceState = "deploying"
+	ServiceStarting  ServiceState = "starting"
+	ServiceStopping  ServiceState = "stopping"
+	ServiceCrashed   ServiceState = "crashed"
+)
+
+// Settable reports whether state may be requested through SetServiceState.
+func (state ServiceState) Settable() bool {
+	switch state {
+	case ServiceRunning, ServiceStopped, ServiceRestarted:
+		return true
+	}
+	return false
+}
+
+// settled returns the state a service reports once a request for state
+// has taken effect.
+func (state ServiceState) settled() ServiceState {
+	if state == ServiceRestarted {
+		return ServiceRunning
+	}
+	return state
+}
+
+// Start requests the service to run. Use Converge to wait for it.
+func (s *Service) Start() error {
+	return s.SetServiceState(ServiceRunning)
+}
+
+// Stop requests the service to stop. Use Converge to wait for it.
+func (s *Service) Stop() error {
+	return s.SetServiceState(ServiceStopped)
+}
+
+// Restart requests the service to restart. Use Converge to wait for it.
 func (s *Service) Restart() error {
-	return s.SetState("restarted")
+	return s.SetServiceState(ServiceRestarted)
 }
 
+// Scale requests n replicas of the service. Use Converge to wait for it.
+func (s *Service) Scale(n int) error {
+	return s.SetReplicas(n)
+}
+
+// SetState requests the state named state; see SetServiceState.
 func (s *Service) SetState(state string) error {
+	return s.SetServiceState(ServiceState(state))
+}
+
+// SetServiceState requests state, which must be settable. Use Converge
+// to wait for it.
+func (s *Service) SetServiceState(state ServiceState) error {
+	if !state.Settable() {
+		return fmt.Errorf("invalid service state %q; must be one of %q, %q or %q",
+			state, ServiceRunning, ServiceStopped, ServiceRestarted)
+	}
+	if state == ServiceRestarted {
+		// learn the current restart time so the new one can be told
+		// apart; the one held by s may be stale
+		current, err := s.r.GetFromURL(*s.URL)
+		if err != nil {
+			return err
+		}
+		s.Restarted = current.Restarted
+	}
+	desired := string(state)
 	desiredService := Service{
-		DesiredState: &state,
+		DesiredState: &desired,
 	}
 	u, _ := url.Parse(*s.URL)
-	_, err := s.r.client.Patch(u, &desiredService, nil)
+	var res *Service
+	_, err := s.r.client.Patch(u, &desiredService, &res)
 	if err != nil {
 		return err
 	}
+	s.wantState = state.settled()
+	s.restartPending = state == ServiceRestarted
+	s.restartMark = stringValue(s.Restarted)
+	if res != nil {
+		s.observe(res)
+	}
 	return nil
 }
 
+// observe records the service as reported by the API after the last
+// state change request.
+func (s *Service) observe(service *Service) {
+	if !s.restartPending {
+		return
+	}
+	if service.Restarted == nil {
+		// without a restart time there is nothing to wait for
+		s.restartPending = false
+		return
+	}
+	if *service.Restarted != s.restartMark {
+		s.restartPending = false
+	}
+}
+
 func (s *Service) SetReplicas(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid replica count %d; must not be negative", n)
+	}
 	desiredService := Service{
 		DesiredReplicas: &n,
 	}
@@ -137,26 +254,175 @@ func (s *Service) SetReplicas(n int) error {
 	if err != nil {
 		return err
 	}
+	s.wantReplicas = &n
 	return nil
 }
 
+// Converge blocks until the service reports the state and replica count
+// last requested through this value, refreshing its fields as it polls.
+// After Restart the service must report a new restart time before it
+// counts as restarted. The service is always polled at least
+// once; a negative timeout waits forever. If the service does not
+// converge an ErrNotConverged describing the last observed state is
+// returned.
+func (s *Service) Converge(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		service, err := s.r.GetFromURL(*s.URL)
+		if err != nil {
+			return err
+		}
+		s.State = service.State
+		s.Replicas = service.Replicas
+		s.observe(service)
+		s.Restarted = service.Restarted
+		if s.State != nil {
+			if ServiceState(*s.State) == ServiceCrashed && s.wantState != ServiceStopped {
+				return s.notConverged(fmt.Errorf("service crashed"))
+			}
+		}
+		if s.converged() {
+			return nil
+		}
+		wait := time.Second
+		if timeout >= 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				if s.restartPending {
+					return s.notConverged(fmt.Errorf("%s: restart was not observed", ErrTimeout))
+				}
+				return s.notConverged(ErrTimeout)
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (s *Service) converged() bool {
+	if s.restartPending {
+		return false
+	}
+	if s.wantState != "" && (s.State == nil || ServiceState(*s.State) != s.wantState) {
+		return false
+	}
+	if s.wantReplicas != nil && (s.Replicas == nil || *s.Replicas != *s.wantReplicas) {
+		return false
+	}
+	return true
+}
+
+func (s *Service) notConverged(reason error) error {
+	e := ErrNotConverged{
+		Service:         stringValue(s.Name),
+		DesiredState:    string(s.wantState),
+		DesiredReplicas: -1,
+		State:           stringValue(s.State),
+		Replicas:        -1,
+		Err:             reason,
+	}
+	if s.wantReplicas != nil {
+		e.DesiredReplicas = *s.wantReplicas
+	}
+	if s.Replicas != nil {
+		e.Replicas = *s.Replicas
+	}
+	return e
+}
+
+// RunOptions controls how a one-off command is executed by RunWithOptions.
+type RunOptions struct {
+	// Size overrides the service size for this run only.
+	Size string
+	// Env is merged over the service environment for this run only.
+	Env map[string]string
+	// WorkingDir sets the directory the command is started in.
+	WorkingDir string
+	// Timeout bounds how long the command may run; zero means no limit.
+	Timeout time.Duration
+	// Detached starts the command in the background and returns without
+	// an attach endpoint. Use the returned Task to follow it.
+	Detached bool
+}
+
+// Run executes cmd on the service and returns an endpoint to attach to.
 func (s *Service) Run(cmd []string, size string) (string, error) {
+	task, err := s.RunWithOptions(cmd, RunOptions{Size: size})
+	if err != nil {
+		return "", err
+	}
+	if task.Endpoint == nil {
+		return "", nil
+	}
+	return *task.Endpoint, nil
+}
+
+// RunWithOptions executes cmd on the service. Arguments are sent as an
+// argv list so they reach the process exactly as given.
+func (s *Service) RunWithOptions(cmd []string, opts RunOptions) (*Task, error) {
+	if len(cmd) == 0 {
+		return nil, errors.New("run: command must not be empty")
+	}
 	u, _ := url.Parse(*s.URL + "run/")
 	up := struct {
-		Command string `json:"command,omitempty"`
-		Size *string `json:"size,omitempty"`
+		Command    string            `json:"command,omitempty"`
+		Argv       []string          `json:"argv"`
+		Size       *string           `json:"size,omitempty"`
+		Env        map[string]string `json:"env,omitempty"`
+		WorkingDir *string           `json:"working_dir,omitempty"`
+		Timeout    *int              `json:"timeout,omitempty"`
+		Detached   bool              `json:"detached,omitempty"`
 	}{
-		Command: strings.Join(cmd, " "),
+		Command:  shellJoin(cmd),
+		Argv:     cmd,
+		Env:      opts.Env,
+		Detached: opts.Detached,
+	}
+	if opts.Size != "" {
+		up.Size = &opts.Size
 	}
-	if size != "" {
-		up.Size = &size
+	if opts.WorkingDir != "" {
+		up.WorkingDir = &opts.WorkingDir
 	}
-	down := struct {
-		Endpoint string `json:"endpoint"`
-	}{}
-	_, err := s.r.client.Post(u, &up, &down)
+	if opts.Timeout > 0 {
+		timeout := int(opts.Timeout / time.Second)
+		if timeout == 0 {
+			timeout = 1
+		}
+		up.Timeout = &timeout
+	}
+	var task Task
+	_, err := s.r.client.Post(u, &up, &task)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if task.Service == nil {
+		task.Service = s.URL
+	}
+	task.r = s.r.client.Tasks
+	return &task, nil
+}
+
+// shellJoin quotes each argument so the joined string splits back into
+// the same argv under POSIX shell rules.
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg != "" && strings.IndexFunc(arg, needsShellQuote) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func needsShellQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
 	}
-	return down.Endpoint, nil
+	return !strings.ContainsRune("-_./:=@%+,", r)
 }
@@ -0,0 +1,240 @@
+package gondor
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SiteRole is the access a user has to a site.
+type SiteRole string
+
+const (
+	SiteRoleAdmin     SiteRole = "admin"
+	SiteRoleDeveloper SiteRole = "developer"
+	SiteRoleReadOnly  SiteRole = "read-only"
+)
+
+var siteRoles = []SiteRole{SiteRoleAdmin, SiteRoleDeveloper, SiteRoleReadOnly}
+
+// ParseSiteRole returns the SiteRole named by s.
+func ParseSiteRole(s string) (SiteRole, error) {
+	role := SiteRole(strings.ToLower(s))
+	if err := role.validate(); err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (role SiteRole) validate() error {
+	for _, r := range siteRoles {
+		if role == r {
+			return nil
+		}
+	}
+	names := make([]string, len(siteRoles))
+	for i, r := range siteRoles {
+		names[i] = string(r)
+	}
+	return fmt.Errorf("invalid site role %q (must be one of %s)", string(role), strings.Join(names, ", "))
+}
+
+// Invited reports whether the user has been invited by email but has not
+// signed up yet.
+func (user *SiteUser) Invited() bool {
+	return user.Username == nil || *user.Username == ""
+}
+
+// AddUserWithRole gives the user with email access to the site and
+// returns the new member. If nobody has signed up with email yet, an
+// invitation is sent instead; see SiteUser.Invited.
+func (site *Site) AddUserWithRole(email string, role SiteRole) (*SiteUser, error) {
+	if err := role.validate(); err != nil {
+		return nil, err
+	}
+	url := site.r.client.buildBaseURL("site_users/")
+	r := string(role)
+	req := &SiteUser{
+		Site:  site.URL,
+		Email: &email,
+		Role:  &r,
+	}
+	var res *SiteUser
+	_, err := site.r.client.Post(url, &req, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		res.r = site.r
+		return res, nil
+	}
+	// without a response body the request says nothing about whether
+	// the user has signed up, so look the new member up
+	users, err := site.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if strings.EqualFold(stringValue(u.Email), email) {
+			return u, nil
+		}
+	}
+	return nil, ErrNotFound{msg: fmt.Sprintf("site user %q was not found after adding it", email)}
+}
+
+// SetRole changes the user's role on the site.
+func (user *SiteUser) SetRole(role SiteRole) error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	r := string(role)
+	req := &SiteUser{Role: &r}
+	u, _ := url.Parse(*user.URL)
+	_, err := user.r.client.Patch(u, req, nil)
+	if err != nil {
+		return err
+	}
+	user.Role = &r
+	return nil
+}
+
+// Remove revokes the user's access to the site, or withdraws the
+// invitation if the user has not signed up yet.
+func (user *SiteUser) Remove() error {
+	u, _ := url.Parse(*user.URL)
+	_, err := user.r.client.Delete(u, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DesiredSiteUser is a member a site should have after SyncUsers.
+type DesiredSiteUser struct {
+	Email string
+	Role  SiteRole
+}
+
+// SiteUserChange is a role change made by SyncUsers.
+type SiteUserChange struct {
+	Email   string
+	OldRole SiteRole
+	NewRole SiteRole
+}
+
+// SiteUserSync reports what SyncUsers changed, or would change on a dry
+// run.
+type SiteUserSync struct {
+	Added   []DesiredSiteUser
+	Changed []SiteUserChange
+	Removed []string
+	// Invited lists the added emails that have no account and were sent
+	// an invitation; it is empty on a dry run.
+	Invited []string
+}
+
+// Empty reports whether the site's membership already matched.
+func (s *SiteUserSync) Empty() bool {
+	return len(s.Added)+len(s.Changed)+len(s.Removed) == 0
+}
+
+func (s *SiteUserSync) String() string {
+	invited := make(map[string]bool)
+	for _, email := range s.Invited {
+		invited[email] = true
+	}
+	var buf bytes.Buffer
+	for _, u := range s.Added {
+		fmt.Fprintf(&buf, "+ %s (%s)", u.Email, u.Role)
+		if invited[u.Email] {
+			buf.WriteString(" invited")
+		}
+		buf.WriteByte('\n')
+	}
+	for _, c := range s.Changed {
+		fmt.Fprintf(&buf, "~ %s: %s -> %s\n", c.Email, c.OldRole, c.NewRole)
+	}
+	for _, email := range s.Removed {
+		fmt.Fprintf(&buf, "- %s\n", email)
+	}
+	return buf.String()
+}
+
+// SyncUsers reconciles the site's members and pending invitations with
+// desired, matching users by email case-insensitively. Members missing
+// from desired are removed, except the authenticated user so a sync
+// cannot lock its caller out. With dryRun nothing is changed.
+func (site *Site) SyncUsers(desired []DesiredSiteUser, dryRun bool) (*SiteUserSync, error) {
+	want := make(map[string]DesiredSiteUser)
+	for _, d := range desired {
+		if err := d.Role.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", d.Email, err)
+		}
+		email := strings.ToLower(d.Email)
+		if _, dup := want[email]; dup {
+			return nil, fmt.Errorf("%s is listed more than once", d.Email)
+		}
+		want[email] = d
+	}
+	users, err := site.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	self := site.r.client.cfg.Auth.Username
+
+	report := &SiteUserSync{}
+	var changed, removed []*SiteUser
+	for _, u := range users {
+		email := strings.ToLower(stringValue(u.Email))
+		d, ok := want[email]
+		if !ok {
+			if self != "" && stringValue(u.Username) == self {
+				continue
+			}
+			removed = append(removed, u)
+			report.Removed = append(report.Removed, stringValue(u.Email))
+			continue
+		}
+		delete(want, email)
+		if SiteRole(stringValue(u.Role)) != d.Role {
+			changed = append(changed, u)
+			report.Changed = append(report.Changed, SiteUserChange{
+				Email:   stringValue(u.Email),
+				OldRole: SiteRole(stringValue(u.Role)),
+				NewRole: d.Role,
+			})
+		}
+	}
+	for _, d := range want {
+		report.Added = append(report.Added, d)
+	}
+	sort.Slice(report.Added, func(i, j int) bool {
+		return report.Added[i].Email < report.Added[j].Email
+	})
+	if dryRun {
+		return report, nil
+	}
+
+	for _, d := range report.Added {
+		u, err := site.AddUserWithRole(d.Email, d.Role)
+		if err != nil {
+			return report, fmt.Errorf("adding %s: %s", d.Email, err)
+		}
+		if u.Invited() {
+			report.Invited = append(report.Invited, d.Email)
+		}
+	}
+	for i, u := range changed {
+		if err := u.SetRole(report.Changed[i].NewRole); err != nil {
+			return report, fmt.Errorf("changing role of %s: %s", stringValue(u.Email), err)
+		}
+	}
+	for _, u := range removed {
+		if err := u.Remove(); err != nil {
+			return report, fmt.Errorf("removing %s: %s", stringValue(u.Email), err)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,239 @@
+package gondor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSiteUsers serves the site_users endpoints for a single site.
+type fakeSiteUsers struct {
+	mu       sync.Mutex
+	srv      *httptest.Server
+	users    map[string]*SiteUser
+	next     int
+	accounts map[string]string // email to username
+	// emptyPost makes POST answer without a body
+	emptyPost bool
+	requests  []string
+}
+
+func newFakeSiteUsers(t *testing.T, accounts map[string]string, members ...SiteUser) *fakeSiteUsers {
+	f := &fakeSiteUsers{users: make(map[string]*SiteUser), accounts: accounts}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	for i := range members {
+		f.add(members[i])
+	}
+	return f
+}
+
+func (f *fakeSiteUsers) add(u SiteUser) *SiteUser {
+	f.next++
+	url := fmt.Sprintf("%s/v2/site_users/%d/", f.srv.URL, f.next)
+	u.URL = &url
+	f.users[url] = &u
+	return &u
+}
+
+func (f *fakeSiteUsers) serve(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req.Method+" "+req.URL.Path)
+	url := f.srv.URL + req.URL.Path
+	switch {
+	case req.Method == "GET" && req.URL.Path == "/v2/site_users/":
+		users := []*SiteUser{}
+		for _, u := range f.users {
+			users = append(users, u)
+		}
+		sort.Slice(users, func(i, j int) bool { return *users[i].URL < *users[j].URL })
+		json.NewEncoder(w).Encode(users)
+	case req.Method == "POST" && req.URL.Path == "/v2/site_users/":
+		var u SiteUser
+		json.NewDecoder(req.Body).Decode(&u)
+		if name, ok := f.accounts[strings.ToLower(*u.Email)]; ok {
+			u.Username = &name
+		}
+		created := f.add(u)
+		w.WriteHeader(http.StatusCreated)
+		if !f.emptyPost {
+			json.NewEncoder(w).Encode(created)
+		}
+	case req.Method == "PATCH" && f.users[url] != nil:
+		var u SiteUser
+		json.NewDecoder(req.Body).Decode(&u)
+		f.users[url].Role = u.Role
+		json.NewEncoder(w).Encode(f.users[url])
+	case req.Method == "DELETE" && f.users[url] != nil:
+		delete(f.users, url)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"detail": "Not found."}`)
+	}
+}
+
+// members returns email:role of every member, sorted.
+func (f *fakeSiteUsers) members() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var members []string
+	for _, u := range f.users {
+		members = append(members, *u.Email+":"+*u.Role)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (f *fakeSiteUsers) site(self string) *Site {
+	cfg := &Config{BaseURL: f.srv.URL}
+	cfg.Auth.Username = self
+	c := NewClient(cfg, &http.Client{})
+	url := f.srv.URL + "/v2/sites/1/"
+	return &Site{URL: &url, r: c.Sites}
+}
+
+func siteMember(username, email string, role SiteRole) SiteUser {
+	r := string(role)
+	u := SiteUser{Email: &email, Role: &r}
+	if username != "" {
+		u.Username = &username
+	}
+	return u
+}
+
+func TestSyncUsers(t *testing.T) {
+	f := newFakeSiteUsers(t,
+		map[string]string{"new@example.com": "new"},
+		siteMember("me", "me@example.com", SiteRoleAdmin),
+		siteMember("keep", "keep@example.com", SiteRoleDeveloper),
+		siteMember("promote", "Promote@Example.com", SiteRoleReadOnly),
+		siteMember("gone", "gone@example.com", SiteRoleDeveloper),
+		siteMember("", "pending@example.com", SiteRoleReadOnly),
+	)
+	desired := []DesiredSiteUser{
+		{Email: "keep@example.com", Role: SiteRoleDeveloper},
+		{Email: "promote@example.com", Role: SiteRoleAdmin},
+		{Email: "new@example.com", Role: SiteRoleDeveloper},
+		{Email: "invitee@example.com", Role: SiteRoleReadOnly},
+	}
+	report, err := f.site("me").SyncUsers(desired, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SiteUserSync{
+		Added: []DesiredSiteUser{
+			{Email: "invitee@example.com", Role: SiteRoleReadOnly},
+			{Email: "new@example.com", Role: SiteRoleDeveloper},
+		},
+		Changed: []SiteUserChange{{Email: "Promote@Example.com", OldRole: SiteRoleReadOnly, NewRole: SiteRoleAdmin}},
+		Removed: []string{"gone@example.com", "pending@example.com"},
+		Invited: []string{"invitee@example.com"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got report %+v, want %+v", report, want)
+	}
+	members := []string{
+		"Promote@Example.com:admin",
+		"invitee@example.com:read-only",
+		"keep@example.com:developer",
+		"me@example.com:admin",
+		"new@example.com:developer",
+	}
+	if got := f.members(); !reflect.DeepEqual(got, members) {
+		t.Errorf("got members %q, want %q", got, members)
+	}
+	wantString := "+ invitee@example.com (read-only) invited\n" +
+		"+ new@example.com (developer)\n" +
+		"~ Promote@Example.com: read-only -> admin\n" +
+		"- gone@example.com\n" +
+		"- pending@example.com\n"
+	if report.String() != wantString {
+		t.Errorf("got %q, want %q", report.String(), wantString)
+	}
+
+	// a second sync finds nothing to do
+	report, err = f.site("me").SyncUsers(desired, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Empty() {
+		t.Errorf("second sync was not empty: %+v", report)
+	}
+}
+
+func TestSyncUsersDryRun(t *testing.T) {
+	f := newFakeSiteUsers(t,
+		map[string]string{},
+		siteMember("keep", "keep@example.com", SiteRoleDeveloper),
+		siteMember("gone", "gone@example.com", SiteRoleDeveloper),
+	)
+	before := f.members()
+	report, err := f.site("").SyncUsers([]DesiredSiteUser{
+		{Email: "keep@example.com", Role: SiteRoleReadOnly},
+		{Email: "invitee@example.com", Role: SiteRoleDeveloper},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || len(report.Changed) != 1 || len(report.Removed) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Invited) != 0 {
+		t.Errorf("a dry run reported invitations: %q", report.Invited)
+	}
+	if got := f.members(); !reflect.DeepEqual(got, before) {
+		t.Errorf("a dry run changed members to %q", got)
+	}
+	if want := []string{"GET /v2/site_users/"}; !reflect.DeepEqual(f.requests, want) {
+		t.Errorf("a dry run sent %q", f.requests)
+	}
+}
+
+func TestSyncUsersInvitedWithoutResponseBody(t *testing.T) {
+	f := newFakeSiteUsers(t, map[string]string{"known@example.com": "known"})
+	f.emptyPost = true
+	report, err := f.site("").SyncUsers([]DesiredSiteUser{
+		{Email: "Invitee@example.com", Role: SiteRoleReadOnly},
+		{Email: "known@example.com", Role: SiteRoleDeveloper},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Invitee@example.com"}; !reflect.DeepEqual(report.Invited, want) {
+		t.Errorf("got invited %q, want %q", report.Invited, want)
+	}
+}
+
+func TestSyncUsersRejectsBadInput(t *testing.T) {
+	f := newFakeSiteUsers(t, nil)
+	tests := []struct {
+		desired []DesiredSiteUser
+		want    string
+	}{
+		{
+			[]DesiredSiteUser{{Email: "a@example.com", Role: "owner"}},
+			`a@example.com: invalid site role "owner" (must be one of admin, developer, read-only)`,
+		},
+		{
+			[]DesiredSiteUser{{Email: "a@example.com", Role: SiteRoleAdmin}, {Email: "A@example.com", Role: SiteRoleReadOnly}},
+			"A@example.com is listed more than once",
+		},
+	}
+	for _, tt := range tests {
+		_, err := f.site("").SyncUsers(tt.desired, false)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+	if len(f.requests) != 0 {
+		t.Errorf("invalid input sent %q", f.requests)
+	}
+}
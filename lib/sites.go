@@ -27,6 +27,8 @@ type SiteUser struct {
 	Email    *string `json:"email,omitempty"`
 	Role     *string `json:"role,omitempty"`
 
+	URL *string `json:"url,omitempty"`
+
 	r *SiteResource
 }
 
@@ -89,6 +91,28 @@ func (r *SiteResource) Get(name string, resourceGroupURL *string) (*Site, error)
 	return site, err
 }
 
+func (r *SiteResource) Update(site Site) error {
+	u, _ := url.Parse(*site.URL)
+	site.URL = nil
+	_, err := r.client.Patch(u, &site, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Rename changes the name of site.
+func (r *SiteResource) Rename(site *Site, name string) error {
+	if name == "" {
+		return fmt.Errorf("site name cannot be empty")
+	}
+	if err := r.Update(Site{URL: site.URL, Name: &name}); err != nil {
+		return err
+	}
+	site.Name = &name
+	return nil
+}
+
 func (r *SiteResource) Delete(siteURL string) error {
 	u, _ := url.Parse(siteURL)
 	_, err := r.client.Delete(u, nil)
@@ -0,0 +1,448 @@
+package gondor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Snapshot records the observable state of a resource group or a single
+// site. Each site is stored as a manifest whose environment variable
+// values are replaced by HMACs under a key supplied by the caller, so
+// snapshots can be kept in CI without exposing secrets, and values cannot
+// be guessed from the snapshot without the key. Snapshots are only
+// comparable when taken with the same key.
+type Snapshot struct {
+	Taken time.Time `json:"taken"`
+	// ResourceGroup is the name of the resource group snapshotted; empty
+	// means the authenticated user's resource group.
+	ResourceGroup string `json:"resource_group,omitempty"`
+	// Site is set when only that site was snapshotted.
+	Site string `json:"site,omitempty"`
+	// KeyID fingerprints the key env var values were hashed with, so a
+	// snapshot is never compared using a different key. Snapshots taken
+	// before it was recorded have none and are not checked.
+	KeyID string      `json:"key_id,omitempty"`
+	Sites []*Manifest `json:"sites"`
+}
+
+var (
+	errSnapshotNoKey       = errors.New("snapshot: a key is required to hash env var values")
+	errSnapshotKeyMismatch = errors.New("snapshot: the key differs from the one the snapshot was taken with")
+)
+
+// snapshotKeyLabel is the message whose HMAC identifies a snapshot key.
+const snapshotKeyLabel = "gondor snapshot key"
+
+// snapshotKeyID returns the fingerprint of key stored in snapshots.
+func snapshotKeyID(key []byte) string {
+	return hashEnvValue(key, snapshotKeyLabel)
+}
+
+// hashEnvValue returns the form env var values take in a snapshot.
+func hashEnvValue(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SnapshotSite records the state of site, hashing env var values with
+// key.
+func (c *Client) SnapshotSite(site *Site, key []byte) (*Snapshot, error) {
+	if len(key) == 0 {
+		return nil, errSnapshotNoKey
+	}
+	m, err := c.snapshotManifest(site, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Taken:         time.Now().UTC(),
+		ResourceGroup: m.ResourceGroup,
+		Site:          m.Site,
+		KeyID:         snapshotKeyID(key),
+		Sites:         []*Manifest{m},
+	}, nil
+}
+
+// SnapshotResourceGroup records the state of every site in the named
+// resource group, or in the authenticated user's resource group if name
+// is empty. Env var values are hashed with key.
+func (c *Client) SnapshotResourceGroup(name string, key []byte) (*Snapshot, error) {
+	if len(key) == 0 {
+		return nil, errSnapshotNoKey
+	}
+	var rgURL *string
+	if name != "" {
+		rg, err := c.ResourceGroups.GetByName(name)
+		if err != nil {
+			return nil, err
+		}
+		rgURL = rg.URL
+	}
+	sites, err := c.Sites.List(rgURL)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{
+		Taken:         time.Now().UTC(),
+		ResourceGroup: name,
+		KeyID:         snapshotKeyID(key),
+		Sites:         []*Manifest{},
+	}
+	for _, site := range sites {
+		m, err := c.snapshotManifest(site, key)
+		if err != nil {
+			return nil, err
+		}
+		snap.Sites = append(snap.Sites, m)
+	}
+	sort.Slice(snap.Sites, func(i, j int) bool {
+		return snap.Sites[i].Site < snap.Sites[j].Site
+	})
+	return snap, nil
+}
+
+func (c *Client) snapshotManifest(site *Site, key []byte) (*Manifest, error) {
+	m, err := c.ExportManifest(site, ExportOpts{Secrets: true})
+	if err != nil {
+		return nil, err
+	}
+	hashEnv(key, m.Env)
+	for _, mi := range m.Instances {
+		hashEnv(key, mi.Env)
+		for _, ms := range mi.Services {
+			hashEnv(key, ms.Env)
+		}
+	}
+	return m, nil
+}
+
+func hashEnv(key []byte, env map[string]string) {
+	for k, v := range env {
+		env[k] = hashEnvValue(key, v)
+	}
+}
+
+// Snapshot takes a new snapshot of the same scope as prev, hashing env
+// var values with key.
+func (c *Client) Snapshot(prev *Snapshot, key []byte) (*Snapshot, error) {
+	if prev.Site == "" {
+		return c.SnapshotResourceGroup(prev.ResourceGroup, key)
+	}
+	var rgURL *string
+	if prev.ResourceGroup != "" {
+		rg, err := c.ResourceGroups.GetByName(prev.ResourceGroup)
+		if err != nil {
+			return nil, err
+		}
+		rgURL = rg.URL
+	}
+	site, err := c.Sites.Get(prev.Site, rgURL)
+	if err != nil {
+		return nil, err
+	}
+	return c.SnapshotSite(site, key)
+}
+
+// Drift compares saved against the live state of the same scope. key
+// must be the one saved was taken with; a different key is an error
+// rather than every env var reported as changed.
+func (c *Client) Drift(saved *Snapshot, key []byte) (*Drift, error) {
+	if len(key) == 0 {
+		return nil, errSnapshotNoKey
+	}
+	if saved.KeyID != "" && !hmac.Equal([]byte(saved.KeyID), []byte(snapshotKeyID(key))) {
+		return nil, errSnapshotKeyMismatch
+	}
+	live, err := c.Snapshot(saved, key)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSnapshots(saved, live), nil
+}
+
+// LoadSnapshot decodes a snapshot written by WriteSnapshot.
+func LoadSnapshot(r io.Reader) (*Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("snapshot: %s", err)
+	}
+	return &s, nil
+}
+
+// WriteSnapshot encodes s as indented JSON.
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// DriftKind is how a resource or field differs between two snapshots.
+type DriftKind string
+
+const (
+	DriftAdded   DriftKind = "added"
+	DriftRemoved DriftKind = "removed"
+	DriftChanged DriftKind = "changed"
+)
+
+var driftKindSigils = map[DriftKind]string{
+	DriftAdded:   "+",
+	DriftRemoved: "-",
+	DriftChanged: "~",
+}
+
+// DriftChange is a single difference between two snapshots. Changed
+// fields carry their old and new values; env var values are hashes.
+type DriftChange struct {
+	Kind DriftKind `json:"kind"`
+	// Resource is one of site, instance, service, env, host or
+	// scheduled_task.
+	Resource string `json:"resource"`
+	// Path locates the resource, e.g. "mysite/prod/web".
+	Path  string `json:"path"`
+	Field string `json:"field,omitempty"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// Drift is the difference between two snapshots.
+type Drift struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Changes []DriftChange `json:"changes"`
+}
+
+// Empty reports whether the snapshots matched.
+func (d *Drift) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders the changes as text, one line per change.
+func (d *Drift) String() string {
+	var buf bytes.Buffer
+	for _, c := range d.Changes {
+		fmt.Fprintf(&buf, "%s %s %s", driftKindSigils[c.Kind], c.Resource, c.Path)
+		switch {
+		case c.Resource == "env" && c.Field != "":
+			// hashes only tell that the value changed
+			buf.WriteString(" value changed")
+		case c.Field != "":
+			fmt.Fprintf(&buf, " %s: %q -> %q", c.Field, c.Old, c.New)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// WriteJSON encodes the drift as indented JSON.
+func (d *Drift) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// DiffSnapshots lists what changed from old to new.
+func DiffSnapshots(old, new *Snapshot) *Drift {
+	d := &driftBuilder{drift: &Drift{From: old.Taken, To: new.Taken, Changes: []DriftChange{}}}
+	names := keySet{}
+	oldSites := make(map[string]*Manifest)
+	for _, m := range old.Sites {
+		oldSites[m.Site] = m
+		names[m.Site] = true
+	}
+	newSites := make(map[string]*Manifest)
+	for _, m := range new.Sites {
+		newSites[m.Site] = m
+		names[m.Site] = true
+	}
+	for _, name := range names.sorted() {
+		a, b := oldSites[name], newSites[name]
+		if !d.presence("site", name, a != nil, b != nil) {
+			continue
+		}
+		d.field("site", name, "resource_group", a.ResourceGroup, b.ResourceGroup)
+		d.env(name, a.Env, b.Env)
+		d.instances(name, a.Instances, b.Instances)
+	}
+	return d.drift
+}
+
+type driftBuilder struct {
+	drift *Drift
+}
+
+func (d *driftBuilder) add(c DriftChange) {
+	d.drift.Changes = append(d.drift.Changes, c)
+}
+
+// presence records an addition or removal and reports whether the
+// resource exists on both sides and should be compared further.
+func (d *driftBuilder) presence(resource, path string, before, after bool) bool {
+	switch {
+	case before && !after:
+		d.add(DriftChange{Kind: DriftRemoved, Resource: resource, Path: path})
+	case !before && after:
+		d.add(DriftChange{Kind: DriftAdded, Resource: resource, Path: path})
+	}
+	return before && after
+}
+
+func (d *driftBuilder) field(resource, path, field, old, new string) {
+	if old != new {
+		d.add(DriftChange{Kind: DriftChanged, Resource: resource, Path: path, Field: field, Old: old, New: new})
+	}
+}
+
+func (d *driftBuilder) env(path string, old, new map[string]string) {
+	keys := keySet{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	for _, key := range keys.sorted() {
+		a, inOld := old[key]
+		b, inNew := new[key]
+		if d.presence("env", path+"/"+key, inOld, inNew) {
+			d.field("env", path+"/"+key, "value", a, b)
+		}
+	}
+}
+
+func (d *driftBuilder) instances(site string, old, new []ManifestInstance) {
+	keys := keySet{}
+	a := make(map[string]ManifestInstance)
+	for _, mi := range old {
+		a[mi.Label] = mi
+		keys[mi.Label] = true
+	}
+	b := make(map[string]ManifestInstance)
+	for _, mi := range new {
+		b[mi.Label] = mi
+		keys[mi.Label] = true
+	}
+	for _, label := range keys.sorted() {
+		path := site + "/" + label
+		x, inOld := a[label]
+		y, inNew := b[label]
+		if !d.presence("instance", path, inOld, inNew) {
+			continue
+		}
+		d.field("instance", path, "kind", x.Kind, y.Kind)
+		d.env(path, x.Env, y.Env)
+		d.services(path, x.Services, y.Services)
+		d.hosts(path, x.Hosts, y.Hosts)
+		d.scheduledTasks(path, x.ScheduledTasks, y.ScheduledTasks)
+	}
+}
+
+func (d *driftBuilder) services(instance string, old, new []ManifestService) {
+	keys := keySet{}
+	a := make(map[string]ManifestService)
+	for _, ms := range old {
+		a[ms.Name] = ms
+		keys[ms.Name] = true
+	}
+	b := make(map[string]ManifestService)
+	for _, ms := range new {
+		b[ms.Name] = ms
+		keys[ms.Name] = true
+	}
+	for _, name := range keys.sorted() {
+		path := instance + "/" + name
+		x, inOld := a[name]
+		y, inNew := b[name]
+		if !d.presence("service", path, inOld, inNew) {
+			continue
+		}
+		d.field("service", path, "kind", x.Kind, y.Kind)
+		d.field("service", path, "size", x.Size, y.Size)
+		d.field("service", path, "replicas", replicasString(x.Replicas), replicasString(y.Replicas))
+		d.env(path, x.Env, y.Env)
+	}
+}
+
+// replicasString renders an optional replica count; nil is empty.
+func replicasString(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func (d *driftBuilder) hosts(instance string, old, new []ManifestHost) {
+	keys := keySet{}
+	a := make(map[string]ManifestHost)
+	for _, mh := range old {
+		a[mh.Host] = mh
+		keys[mh.Host] = true
+	}
+	b := make(map[string]ManifestHost)
+	for _, mh := range new {
+		b[mh.Host] = mh
+		keys[mh.Host] = true
+	}
+	for _, host := range keys.sorted() {
+		path := instance + "/" + host
+		x, inOld := a[host]
+		y, inNew := b[host]
+		if d.presence("host", path, inOld, inNew) {
+			d.field("host", path, "keypair", x.KeyPair, y.KeyPair)
+		}
+	}
+}
+
+func (d *driftBuilder) scheduledTasks(instance string, old, new []ManifestScheduledTask) {
+	keys := keySet{}
+	a := make(map[string]ManifestScheduledTask)
+	for _, mt := range old {
+		a[mt.Name] = mt
+		keys[mt.Name] = true
+	}
+	b := make(map[string]ManifestScheduledTask)
+	for _, mt := range new {
+		b[mt.Name] = mt
+		keys[mt.Name] = true
+	}
+	for _, name := range keys.sorted() {
+		path := instance + "/" + name
+		x, inOld := a[name]
+		y, inNew := b[name]
+		if !d.presence("scheduled_task", path, inOld, inNew) {
+			continue
+		}
+		d.field("scheduled_task", path, "schedule", x.Schedule, y.Schedule)
+		d.field("scheduled_task", path, "timezone", x.Timezone, y.Timezone)
+		d.field("scheduled_task", path, "command", x.Command, y.Command)
+	}
+}
+
+type keySet map[string]bool
+
+func (s keySet) sorted() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+package gondor
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type TaskResource struct {
+	client *Client
+}
+
+// Task is a one-off command started with Service.RunWithOptions.
+type Task struct {
+	Service  *string `json:"service,omitempty"`
+	Command  *string `json:"command,omitempty"`
+	State    *string `json:"state,omitempty"`
+	ExitCode *int    `json:"exit_code,omitempty"`
+	Endpoint *string `json:"endpoint,omitempty"`
+	Created  *string `json:"created,omitempty"`
+	Finished *string `json:"finished,omitempty"`
+
+	URL *string `json:"url,omitempty"`
+
+	r *TaskResource
+}
+
+func (r *TaskResource) findOne(url *url.URL) (*Task, error) {
+	var res *Task
+	_, err := r.client.Get(url, &res)
+	if err != nil {
+		return nil, err
+	}
+	res.r = r
+	return res, nil
+}
+
+func (r *TaskResource) GetFromURL(value string) (*Task, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return r.findOne(u)
+}
+
+func (r *TaskResource) List(serviceURL *string) ([]*Task, error) {
+	url := r.client.buildBaseURL("tasks/")
+	q := url.Query()
+	if serviceURL != nil {
+		q.Set("service", *serviceURL)
+	}
+	url.RawQuery = q.Encode()
+	var res []*Task
+	_, err := r.client.Get(url, &res)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		res[i].r = r
+	}
+	return res, nil
+}
+
+// errTaskNoURL is returned when a task cannot be addressed through the
+// API.
+var errTaskNoURL = errors.New("task has no URL; the API did not return one")
+
+// Refresh reloads the task state from the API.
+func (t *Task) Refresh() error {
+	if t.URL == nil {
+		return errTaskNoURL
+	}
+	task, err := t.r.GetFromURL(*t.URL)
+	if err != nil {
+		return err
+	}
+	*t = *task
+	return nil
+}
+
+// Done reports whether the task has stopped running.
+func (t *Task) Done() bool {
+	if t.State == nil {
+		return false
+	}
+	switch *t.State {
+	case "succeeded", "failed", "canceled", "timed-out":
+		return true
+	}
+	return false
+}
+
+// Status refreshes the task and returns its current state.
+func (t *Task) Status() (string, error) {
+	if err := t.Refresh(); err != nil {
+		return "", err
+	}
+	if t.State == nil {
+		return "", nil
+	}
+	return *t.State, nil
+}
+
+// Wait blocks until the task is done and returns its exit code. A
+// negative timeout waits forever.
+func (t *Task) Wait(timeout time.Duration) (int, error) {
+	err := pollUntil(timeout, func() (bool, error) {
+		if err := t.Refresh(); err != nil {
+			return false, err
+		}
+		return t.Done(), nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return t.ExitStatus()
+}
+
+// ExitStatus returns the exit code of a finished task.
+func (t *Task) ExitStatus() (int, error) {
+	if !t.Done() {
+		return -1, fmt.Errorf("task is still %s", valueOrDefault(stringValue(t.State), "pending"))
+	}
+	if t.ExitCode == nil {
+		return -1, fmt.Errorf("task %s without an exit code", *t.State)
+	}
+	return *t.ExitCode, nil
+}
+
+// Logs returns a page of output written by the task.
+func (t *Task) Logs(opts LogRequestOpts) (*LogRecordPage, error) {
+	if t.URL == nil {
+		return nil, errTaskNoURL
+	}
+	return t.r.client.Logs.ListByTask(*t.URL, opts)
+}
+
+// Cancel asks the API to stop a running task.
+func (t *Task) Cancel() error {
+	if t.URL == nil {
+		return errTaskNoURL
+	}
+	u, _ := url.Parse(*t.URL + "cancel/")
+	_, err := t.r.client.Post(u, nil, t)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
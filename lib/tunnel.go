@@ -0,0 +1,580 @@
+package gondor
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// The tunnel protocol runs over a single upgraded HTTP connection. Each
+// frame is a 9 byte header (kind, stream ID, payload length) followed by
+// the payload. The client opens a stream per forwarded connection; the
+// server dials the backing service for it and both sides exchange data
+// frames until either side sends a close frame.
+//
+// Streams are flow controlled so that one slow connection cannot stall
+// the others: each side may have at most tunnelWindow bytes of a stream
+// in flight, and the receiver grants more with a window frame, whose
+// payload is a 4 byte increment, once it has written data out.
+const (
+	tunnelProtocol = "gondor-tunnel"
+
+	frameOpen   byte = 1
+	frameData   byte = 2
+	frameClose  byte = 3
+	frameWindow byte = 4
+
+	frameHeaderSize = 9
+	maxFramePayload = 32 * 1024
+	tunnelWindow    = 8 * maxFramePayload
+)
+
+type tunnelFrame struct {
+	kind    byte
+	stream  uint32
+	payload []byte
+}
+
+func readFrame(r io.Reader) (tunnelFrame, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return tunnelFrame{}, err
+	}
+	f := tunnelFrame{
+		kind:   hdr[0],
+		stream: binary.BigEndian.Uint32(hdr[1:5]),
+	}
+	n := binary.BigEndian.Uint32(hdr[5:9])
+	if n > maxFramePayload {
+		return f, fmt.Errorf("tunnel: frame payload of %d bytes exceeds limit", n)
+	}
+	if n > 0 {
+		f.payload = make([]byte, n)
+		if _, err := io.ReadFull(r, f.payload); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// tunnelMux multiplexes many TCP connections over one stream. The same
+// type serves both ends; only the server sets onOpen.
+type tunnelMux struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+
+	// onOpen dials the backing service for a stream opened by the peer.
+	onOpen func() (net.Conn, error)
+
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	streams map[uint32]*tunnelStream
+	nextID  uint32
+	closed  bool
+}
+
+// tunnelStream is one forwarded connection. Data from the peer is queued
+// by the mux's read loop and written to conn by the stream's own writer,
+// so the read loop never blocks on a connection.
+type tunnelStream struct {
+	id  uint32
+	mux *tunnelMux
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// conn is nil until the backing service has been dialed
+	conn net.Conn
+	// pending holds data from the peer not yet written to conn
+	pending [][]byte
+	queued  int
+	// credit is how many bytes may still be sent to the peer
+	credit       int
+	remoteClosed bool
+	writeDone    bool
+	localClosed  bool
+	dead         bool
+}
+
+func newTunnelMux(rwc io.ReadWriteCloser, r *bufio.Reader) *tunnelMux {
+	if r == nil {
+		r = bufio.NewReader(rwc)
+	}
+	return &tunnelMux{
+		rwc:     rwc,
+		r:       r,
+		streams: make(map[uint32]*tunnelStream),
+	}
+}
+
+func (m *tunnelMux) writeFrame(kind byte, stream uint32, payload []byte) error {
+	var hdr [frameHeaderSize]byte
+	hdr[0] = kind
+	binary.BigEndian.PutUint32(hdr[1:5], stream)
+	binary.BigEndian.PutUint32(hdr[5:9], uint32(len(payload)))
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	if _, err := m.rwc.Write(hdr[:]); err != nil {
+		return err
+	}
+	if len(payload) > 0 {
+		if _, err := m.rwc.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register adds a stream under id, unless the mux is closed.
+func (m *tunnelMux) register(id uint32) (*tunnelStream, error) {
+	s := &tunnelStream{id: id, mux: m, credit: tunnelWindow}
+	s.cond = sync.NewCond(&s.mu)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errors.New("tunnel: closed")
+	}
+	if id == 0 {
+		m.nextID++
+		s.id = m.nextID
+	}
+	m.streams[s.id] = s
+	return s, nil
+}
+
+// open registers conn as a new stream and tells the peer about it.
+func (m *tunnelMux) open(conn net.Conn) error {
+	s, err := m.register(0)
+	if err != nil {
+		return err
+	}
+	if err := m.writeFrame(frameOpen, s.id, nil); err != nil {
+		m.drop(s.id)
+		return err
+	}
+	s.start(conn)
+	return nil
+}
+
+func (m *tunnelMux) stream(id uint32) *tunnelStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+func (m *tunnelMux) drop(id uint32) {
+	m.mu.Lock()
+	s, ok := m.streams[id]
+	delete(m.streams, id)
+	m.mu.Unlock()
+	if ok {
+		s.kill()
+	}
+}
+
+// serve reads frames from the peer until the underlying stream fails or
+// the mux is closed.
+func (m *tunnelMux) serve() error {
+	for {
+		f, err := readFrame(m.r)
+		if err != nil {
+			m.mu.Lock()
+			closed := m.closed
+			m.mu.Unlock()
+			m.Close()
+			if closed || err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if f.kind == frameOpen {
+			m.accept(f.stream)
+			continue
+		}
+		s := m.stream(f.stream)
+		switch f.kind {
+		case frameData:
+			if s != nil && !s.receive(f.payload) {
+				// the peer overran the window it was granted
+				m.writeFrame(frameClose, f.stream, nil)
+				m.drop(f.stream)
+			}
+		case frameWindow:
+			if len(f.payload) != 4 {
+				m.Close()
+				return errors.New("tunnel: malformed window frame")
+			}
+			if s != nil {
+				s.grant(int(binary.BigEndian.Uint32(f.payload)))
+			}
+		case frameClose:
+			if s != nil {
+				s.closeRemote()
+			}
+		default:
+			m.Close()
+			return fmt.Errorf("tunnel: unknown frame kind %d", f.kind)
+		}
+	}
+}
+
+// accept handles a stream opened by the peer. The backing service is
+// dialed in the background so a slow dial does not hold up other streams;
+// data arriving meanwhile is queued.
+func (m *tunnelMux) accept(id uint32) {
+	if m.onOpen == nil || m.stream(id) != nil {
+		m.writeFrame(frameClose, id, nil)
+		return
+	}
+	s, err := m.register(id)
+	if err != nil {
+		return
+	}
+	go func() {
+		conn, err := m.onOpen()
+		if err != nil {
+			m.writeFrame(frameClose, id, nil)
+			m.drop(id)
+			return
+		}
+		s.start(conn)
+	}()
+}
+
+// Close tears down every stream and the underlying connection.
+func (m *tunnelMux) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	streams := m.streams
+	m.streams = make(map[uint32]*tunnelStream)
+	m.mu.Unlock()
+	for _, s := range streams {
+		s.kill()
+	}
+	return m.rwc.Close()
+}
+
+// start attaches conn to the stream and begins copying in both
+// directions.
+func (s *tunnelStream) start(conn net.Conn) {
+	s.mu.Lock()
+	if s.dead {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conn = conn
+	s.mu.Unlock()
+	go s.writeLoop()
+	go s.pump()
+}
+
+// kill closes the stream's connection and stops its goroutines.
+func (s *tunnelStream) kill() {
+	s.mu.Lock()
+	s.dead = true
+	conn := s.conn
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// receive queues data from the peer. It reports false if the data does
+// not fit the window granted to the peer.
+func (s *tunnelStream) receive(data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued+len(data) > tunnelWindow {
+		return false
+	}
+	s.pending = append(s.pending, data)
+	s.queued += len(data)
+	s.cond.Broadcast()
+	return true
+}
+
+func (s *tunnelStream) grant(n int) {
+	s.mu.Lock()
+	s.credit += n
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *tunnelStream) closeRemote() {
+	s.mu.Lock()
+	s.remoteClosed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// writeLoop writes data from the peer to the connection, granting the
+// peer more window as it goes, until the peer closes the stream.
+func (s *tunnelStream) writeLoop() {
+	for {
+		s.mu.Lock()
+		for len(s.pending) == 0 && !s.remoteClosed && !s.dead {
+			s.cond.Wait()
+		}
+		if s.dead {
+			s.mu.Unlock()
+			return
+		}
+		if len(s.pending) == 0 {
+			s.writeDone = true
+			done := s.localClosed
+			s.mu.Unlock()
+			s.closeWrite(done)
+			return
+		}
+		chunk := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+		if _, err := s.conn.Write(chunk); err != nil {
+			s.mux.writeFrame(frameClose, s.id, nil)
+			s.mux.drop(s.id)
+			return
+		}
+		s.mu.Lock()
+		s.queued -= len(chunk)
+		s.mu.Unlock()
+		var inc [4]byte
+		binary.BigEndian.PutUint32(inc[:], uint32(len(chunk)))
+		if err := s.mux.writeFrame(frameWindow, s.id, inc[:]); err != nil {
+			s.mux.drop(s.id)
+			return
+		}
+	}
+}
+
+// closeWrite ends the stream once the peer has closed it, or only the
+// write side of the connection if the local side is still sending.
+func (s *tunnelStream) closeWrite(done bool) {
+	if !done {
+		if cw, ok := s.conn.(interface {
+			CloseWrite() error
+		}); ok {
+			cw.CloseWrite()
+			return
+		}
+	}
+	s.mux.drop(s.id)
+}
+
+// pump copies from the connection to the peer, within the credit the
+// peer has granted, until the connection is exhausted.
+func (s *tunnelStream) pump() {
+	buf := make([]byte, maxFramePayload)
+	for {
+		s.mu.Lock()
+		for s.credit == 0 && !s.dead {
+			s.cond.Wait()
+		}
+		if s.dead {
+			s.mu.Unlock()
+			return
+		}
+		n := s.credit
+		s.mu.Unlock()
+		if n > len(buf) {
+			n = len(buf)
+		}
+		n, err := s.conn.Read(buf[:n])
+		if n > 0 {
+			s.mu.Lock()
+			s.credit -= n
+			s.mu.Unlock()
+			if werr := s.mux.writeFrame(frameData, s.id, buf[:n]); werr != nil {
+				s.mux.drop(s.id)
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.mux.writeFrame(frameClose, s.id, nil)
+	s.mu.Lock()
+	s.localClosed = true
+	done := s.writeDone
+	s.mu.Unlock()
+	if done {
+		s.mux.drop(s.id)
+	}
+}
+
+// Tunnel forwards connections made to a local address to a service.
+type Tunnel struct {
+	// Addr is the address the local listener is bound to.
+	Addr net.Addr
+
+	listener net.Listener
+	mux      *tunnelMux
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Forward asks the API for a tunnel to the service and listens on
+// localAddr, forwarding every accepted connection over a single stream
+// authenticated with a token issued for the tunnel. The tunnel is shut down when ctx is done or
+// Close is called.
+func (s *Service) Forward(ctx context.Context, localAddr string) (*Tunnel, error) {
+	u, _ := url.Parse(*s.URL + "tunnel/")
+	var down struct {
+		Endpoint string `json:"endpoint"`
+		Token    string `json:"token"`
+	}
+	if _, err := s.r.client.Post(u, nil, &down); err != nil {
+		return nil, err
+	}
+	// the account's access token is never handed to the tunnel endpoint
+	if down.Token == "" {
+		return nil, errors.New("tunnel: the API did not issue a tunnel token")
+	}
+	rwc, err := dialTunnel(ctx, s.r.client.httpClient, down.Endpoint, down.Token)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	t := &Tunnel{
+		Addr:     ln.Addr(),
+		listener: ln,
+		mux:      newTunnelMux(rwc, nil),
+		done:     make(chan struct{}),
+	}
+	go t.run(ctx)
+	return t, nil
+}
+
+func dialTunnel(ctx context.Context, httpClient *http.Client, endpoint, token string) (io.ReadWriteCloser, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", tunnelProtocol)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("tunnel: upgrade refused; got %s", resp.Status)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.New("tunnel: upgraded connection is not writable")
+	}
+	return rwc, nil
+}
+
+func (t *Tunnel) run(ctx context.Context) {
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- t.mux.serve() }()
+	go func() {
+		for {
+			conn, err := t.listener.Accept()
+			if err != nil {
+				return
+			}
+			if err := t.mux.open(conn); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+	case <-t.done:
+	}
+	t.shutdown(err)
+}
+
+func (t *Tunnel) shutdown(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	if t.err == nil {
+		t.err = err
+	}
+	t.listener.Close()
+	t.mux.Close()
+}
+
+// Close stops accepting connections and tears down the tunnel.
+func (t *Tunnel) Close() error {
+	t.shutdown(nil)
+	return nil
+}
+
+// Wait blocks until the tunnel is shut down and returns the error that
+// caused it, if any.
+func (t *Tunnel) Wait() error {
+	<-t.done
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// TunnelServer is an http.Handler speaking the server side of the tunnel
+// protocol. It forwards every stream to the connection returned by Dial
+// and can stand in for the Gondor tunnel endpoint when testing locally.
+type TunnelServer struct {
+	// Dial connects to the backing service for a new stream.
+	Dial func() (net.Conn, error)
+	// Token, if set, must be presented as a bearer token.
+	Token string
+}
+
+func (ts *TunnelServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Upgrade") != tunnelProtocol {
+		http.Error(w, "expected tunnel upgrade", http.StatusBadRequest)
+		return
+	}
+	if ts.Token != "" && req.Header.Get("Authorization") != fmt.Sprintf("Bearer %s", ts.Token) {
+		http.Error(w, "invalid tunnel token", http.StatusUnauthorized)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: %s\r\nConnection: Upgrade\r\n\r\n", tunnelProtocol)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	m := newTunnelMux(conn, rw.Reader)
+	m.onOpen = ts.Dial
+	m.serve()
+}
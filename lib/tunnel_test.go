@@ -0,0 +1,253 @@
+package gondor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections and writes back everything it reads.
+// active counts the connections still open.
+type echoServer struct {
+	ln     net.Listener
+	active int32
+}
+
+func newEchoServer(t *testing.T) *echoServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &echoServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.active, 1)
+			go func() {
+				defer atomic.AddInt32(&s.active, -1)
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *echoServer) dial() (net.Conn, error) {
+	return net.Dial("tcp", s.ln.Addr().String())
+}
+
+// newTunnelTestService returns a service whose tunnel endpoint is a
+// TunnelServer forwarding to backend.
+func newTunnelTestService(t *testing.T, backend *echoServer) *Service {
+	const token = "tunnel-token"
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/v2/services/1/tunnel/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"endpoint": srv.URL + "/tunnel",
+			"token":    token,
+		})
+	})
+	mux.Handle("/tunnel", &TunnelServer{Dial: backend.dial, Token: token})
+
+	client := NewClient(&Config{BaseURL: srv.URL}, &http.Client{})
+	serviceURL := srv.URL + "/v2/services/1/"
+	return &Service{URL: &serviceURL, r: client.Services}
+}
+
+func TestTunnelForward(t *testing.T) {
+	backend := newEchoServer(t)
+	defer backend.ln.Close()
+	service := newTunnelTestService(t, backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tunnel, err := service.Forward(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Forward: %s", err)
+	}
+
+	const conns = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, conns)
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", tunnel.Addr.String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			// large enough to span several frames
+			payload := bytes.Repeat([]byte(fmt.Sprintf("conn %d;", i)), 16<<10)
+			go conn.Write(payload)
+			got := make([]byte, len(payload))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				errs <- fmt.Errorf("conn %d: %s", i, err)
+				return
+			}
+			if !bytes.Equal(got, payload) {
+				errs <- fmt.Errorf("conn %d: echoed payload differs", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// hold a connection open across shutdown
+	conn, err := net.Dial("tcp", tunnel.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- tunnel.Wait() }()
+	select {
+	case err := <-waitErr:
+		if err != nil {
+			t.Errorf("Wait after cancel: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel did not shut down after ctx was cancelled")
+	}
+	if c, err := net.DialTimeout("tcp", tunnel.Addr.String(), time.Second); err == nil {
+		c.Close()
+		t.Error("local listener still accepts connections after shutdown")
+	}
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("forwarded connection still open after shutdown")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&backend.active) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d backend connections still open after shutdown", atomic.LoadInt32(&backend.active))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestTunnelStreamsIndependent checks that a stream whose backend is slow
+// to dial and one whose backend does not read hold up neither each other
+// nor a third stream.
+func TestTunnelStreamsIndependent(t *testing.T) {
+	backend := newEchoServer(t)
+	defer backend.ln.Close()
+	release := make(chan struct{})
+	defer close(release)
+	var dials int32
+	dial := func() (net.Conn, error) {
+		switch atomic.AddInt32(&dials, 1) {
+		case 1:
+			<-release
+			return nil, fmt.Errorf("dial abandoned")
+		case 2:
+			// nobody ever reads from the other end
+			conn, _ := net.Pipe()
+			return conn, nil
+		}
+		return backend.dial()
+	}
+	srv := httptest.NewServer(&TunnelServer{Dial: dial})
+	defer srv.Close()
+	rwc, err := dialTunnel(context.Background(), &http.Client{}, srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := newTunnelMux(rwc, nil)
+	defer mux.Close()
+	go mux.serve()
+
+	// dials run concurrently, so wait for each before opening the next
+	// to know which stream gets which backend
+	open := func() net.Conn {
+		want := atomic.LoadInt32(&dials) + 1
+		local, remote := net.Pipe()
+		if err := mux.open(remote); err != nil {
+			t.Fatal(err)
+		}
+		for atomic.LoadInt32(&dials) < want {
+			time.Sleep(time.Millisecond)
+		}
+		return local
+	}
+	slowDial := open()
+	defer slowDial.Close()
+	go slowDial.Write(bytes.Repeat([]byte("a"), 1<<20))
+	slowReader := open()
+	defer slowReader.Close()
+	go slowReader.Write(bytes.Repeat([]byte("b"), 1<<20))
+
+	conn := open()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	payload := bytes.Repeat([]byte("ping;"), 1<<14)
+	go conn.Write(payload)
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("stream stalled behind slow ones: %s", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("echoed payload differs")
+	}
+}
+
+func TestTunnelForwardRequiresToken(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/v2/services/1/tunnel/", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"endpoint": srv.URL + "/tunnel"})
+	})
+	cfg := &Config{BaseURL: srv.URL}
+	cfg.Auth.AccessToken = "account-token"
+	client := NewClient(cfg, &http.Client{})
+	serviceURL := srv.URL + "/v2/services/1/"
+	service := &Service{URL: &serviceURL, r: client.Services}
+	if _, err := service.Forward(context.Background(), "127.0.0.1:0"); err == nil {
+		t.Fatal("expected Forward to fail without a tunnel token")
+	}
+}
+
+func TestTunnelServerRejectsBadToken(t *testing.T) {
+	srv := httptest.NewServer(&TunnelServer{
+		Dial:  func() (net.Conn, error) { return nil, fmt.Errorf("unexpected dial") },
+		Token: "secret",
+	})
+	defer srv.Close()
+	_, err := dialTunnel(context.Background(), &http.Client{}, srv.URL, "wrong")
+	if err == nil {
+		t.Fatal("expected the upgrade to be refused")
+	}
+}
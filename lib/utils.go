@@ -2,18 +2,24 @@ package gondor
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 )
 
+// ErrTimeout is returned by WaitFor when its timeout is exceeded.
+var ErrTimeout = errors.New("A timeout occurred")
+
 func WaitFor(timeout int, predicate func() (bool, error)) error {
-	start := time.Now().Second()
+	start := time.Now()
 	for {
 		// Force a 1s sleep
 		time.Sleep(1 * time.Second)
 
 		// If a timeout is set, and that's been exceeded, shut it down
-		if timeout >= 0 && time.Now().Second()-start >= timeout {
-			return errors.New("A timeout occurred")
+		if timeout >= 0 && time.Since(start) >= time.Duration(timeout)*time.Second {
+			return ErrTimeout
 		}
 
 		// Execute the function
@@ -26,3 +32,59 @@ func WaitFor(timeout int, predicate func() (bool, error)) error {
 		}
 	}
 }
+
+// pollUntil calls predicate until it is satisfied or timeout has passed,
+// sleeping at most a second between calls. predicate is always called at
+// least once, so timeouts shorter than a second still poll; a negative
+// timeout waits forever.
+func pollUntil(timeout time.Duration, predicate func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		satisfied, err := predicate()
+		if err != nil {
+			return err
+		}
+		if satisfied {
+			return nil
+		}
+		wait := time.Second
+		if timeout >= 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return ErrTimeout
+			}
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// writeFileAtomic replaces the file at path with data so that an
+// interruption never leaves it half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// durationOrDefault returns d, or def if d is not positive.
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}